@@ -0,0 +1,117 @@
+package rqlobj
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	// backups are verified with the local sqlite
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// BackupFormat is the format of a cluster backup
+type BackupFormat int
+
+const (
+	// BackupSQLite is a SQLite database file
+	BackupSQLite BackupFormat = iota
+
+	// BackupSQL is a dump of SQL statements
+	BackupSQL
+)
+
+// sqliteHeader starts every SQLite database file
+const sqliteHeader = "SQLite format 3\x00"
+
+// Backup writes a backup of the cluster database to w in the format.
+// A SQLite file is verified with the local sqlite before it is written,
+// so w receives nothing if the backup is not a sound database
+func (db RDB) Backup(w io.Writer, format BackupFormat) error {
+	query := url.Values{}
+	switch format {
+	case BackupSQLite:
+	case BackupSQL:
+		query.Set("fmt", "sql")
+	default:
+		return fmt.Errorf("unsupported backup format: %d", format)
+	}
+	resp, err := db.apiRequest("GET", "/db/backup", query, nil, nil, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if format == BackupSQL {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	f, err := ioutil.TempFile("", "rqlobj-backup-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("downloading backup: %w", err)
+	}
+	if err := verifyBackup(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// verifyBackup checks the integrity of the SQLite database file
+func verifyBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(sqliteHeader))
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil || string(header) != sqliteHeader {
+		return fmt.Errorf("backup is not a SQLite database")
+	}
+	local, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	var result string
+	if err := local.QueryRow("pragma integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("verifying backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup failed integrity check: %s", result)
+	}
+	return nil
+}
+
+// Restore replaces the cluster database with the backup read from r,
+// either a SQLite file or a dump of SQL statements, as made by Backup
+func (db RDB) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	contentType := "text/plain"
+	if header, _ := br.Peek(len(sqliteHeader)); bytes.Equal(header, []byte(sqliteHeader)) {
+		contentType = "application/octet-stream"
+	}
+	header := http.Header{"Content-Type": []string{contentType}}
+	resp, err := db.apiRequest("POST", "/db/load", nil, br, header, true)
+	if db.cache != nil {
+		db.cache.clear()
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResults(resp.Body)
+}
@@ -0,0 +1,219 @@
+package rqlobj
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiServer returns an RDB using the handler for its api endpoints
+func apiServer(t *testing.T, handler http.HandlerFunc) RDB {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	base, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RDB{base: base, client: server.Client(), leader: &leaderCache{}}
+}
+
+// sqliteServer returns an RDB whose statements are run by a local
+// sqlite database, standing in for a single node cluster, and that database
+func sqliteServer(t *testing.T) (RDB, *sql.DB) {
+	t.Helper()
+	local, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the memory database and its pragmas belong to its only connection
+	local.SetMaxOpenConns(1)
+	t.Cleanup(func() { local.Close() })
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var statements []string
+		if err := json.NewDecoder(r.Body).Decode(&statements); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, transaction := r.URL.Query()["transaction"]
+		if transaction {
+			local.Exec("begin")
+		}
+		var reply statementsReply
+		for _, statement := range statements {
+			var result statementResult
+			if r.URL.Path == "/db/query" {
+				result = localQuery(local, statement)
+			} else if res, err := local.Exec(statement); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.LastInsertID, _ = res.LastInsertId()
+				result.RowsAffected, _ = res.RowsAffected()
+			}
+			reply.Results = append(reply.Results, result)
+			if result.Error != "" && transaction {
+				break
+			}
+		}
+		if transaction {
+			if len(reply.Results) < len(statements) {
+				local.Exec("rollback")
+			} else {
+				local.Exec("commit")
+			}
+		}
+		json.NewEncoder(w).Encode(reply)
+	})
+	return db, local
+}
+
+// localQuery returns the result of the query run by the local database,
+// as rqlite would report it
+func localQuery(local *sql.DB, query string) statementResult {
+	var result statementResult
+	rows, err := local.Query(query)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer rows.Close()
+	result.Columns, _ = rows.Columns()
+	types, _ := rows.ColumnTypes()
+	for _, t := range types {
+		result.Types = append(result.Types, strings.ToLower(t.DatabaseTypeName()))
+	}
+	for rows.Next() {
+		row := make([]interface{}, len(result.Columns))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		for i, value := range row {
+			switch v := value.(type) {
+			case []byte:
+				row[i] = string(v)
+			case time.Time:
+				// times are stored as unix time
+				row[i] = v.Unix()
+			}
+		}
+		result.Values = append(result.Values, row)
+	}
+	return result
+}
+
+// sqliteFile returns the contents of a new SQLite database
+func sqliteFile(t *testing.T) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backup.db")
+	local, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Exec("create table t (id integer primary key); insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	local.Close()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBackup(t *testing.T) {
+	file := sqliteFile(t)
+	const dump = "BEGIN TRANSACTION;\nCOMMIT;\n"
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/backup" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("fmt") == "sql" {
+			w.Write([]byte(dump))
+			return
+		}
+		w.Write(file)
+	})
+	var buf bytes.Buffer
+	if err := db.Backup(&buf, BackupSQLite); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), file) {
+		t.Errorf("backup does not match the database file")
+	}
+	buf.Reset()
+	if err := db.Backup(&buf, BackupSQL); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != dump {
+		t.Errorf("got %q want %q", buf.String(), dump)
+	}
+}
+
+func TestBackupVerify(t *testing.T) {
+	file := sqliteFile(t)
+	// a truncated database fails verification
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(file[:len(file)/2])
+	})
+	var buf bytes.Buffer
+	if err := db.Backup(&buf, BackupSQLite); err == nil {
+		t.Errorf("expected error for a truncated backup")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("failed backup was written")
+	}
+	db = apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+	})
+	if err := db.Backup(&buf, BackupSQLite); err == nil || !strings.Contains(err.Error(), "not the leader") {
+		t.Errorf("expected the api error but got %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	file := sqliteFile(t)
+	var contentType string
+	var body []byte
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/db/load" {
+			http.NotFound(w, r)
+			return
+		}
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("bogus")) {
+			w.Write([]byte(`{"results":[{"error":"near \"bogus\": syntax error"}]}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{}]}`))
+	})
+	if err := db.Restore(bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/octet-stream" || !bytes.Equal(body, file) {
+		t.Errorf("database file sent as %s", contentType)
+	}
+	if err := db.Restore(strings.NewReader("create table t (id integer);")); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "text/plain" {
+		t.Errorf("sql dump sent as %s", contentType)
+	}
+	if err := db.Restore(strings.NewReader("bogus;")); err == nil {
+		t.Errorf("expected the reported error")
+	}
+}
@@ -0,0 +1,235 @@
+package rqlobj
+
+import (
+	"container/list"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStats are the counts of a cache's use
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64 // entries dropped to stay within size or expired
+	Entries   int
+}
+
+// cache holds the field values of objects loaded by key, least recently used first out
+type cache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]map[string]*list.Element // table -> key -> entry
+	stats   CacheStats
+	// invalidations of each table and of all of them, so that objects
+	// read before one are not put in the cache after it
+	invalidated map[string]uint64
+	cleared     uint64
+}
+
+type cacheEntry struct {
+	table   string
+	key     string
+	values  []interface{}
+	expires time.Time
+}
+
+// WithCache returns a copy of the db that caches objects loaded by their keys
+// with LoadBy, LoadSelf, LoadByID or Load, holding up to size objects for
+// no longer than ttl (forever if zero). Objects of a table are dropped from
+// the cache when any are added, updated or deleted through the db or its
+// copies; writes made otherwise, e.g., with Write, are not seen
+func (db RDB) WithCache(size int, ttl time.Duration) RDB {
+	db.cache = &cache{
+		size:    size,
+		ttl:     ttl,
+		lru:         list.New(),
+		entries:     make(map[string]map[string]*list.Element),
+		invalidated: make(map[string]uint64),
+	}
+	return db
+}
+
+// CacheStats returns the counts of the cache's use
+func (db RDB) CacheStats() CacheStats {
+	if db.cache == nil {
+		return CacheStats{}
+	}
+	db.cache.Lock()
+	defer db.cache.Unlock()
+	stats := db.cache.stats
+	stats.Entries = db.cache.lru.Len()
+	return stats
+}
+
+// cacheKey returns the cache key of the object for the key columns and their
+// values, which must be its key fields for the object to be cached
+func cacheKey(o DBObject, columns []string, values []interface{}) (string, bool) {
+	keys := o.KeyFields()
+	if len(keys) == 0 || len(columns) != len(keys) {
+		return "", false
+	}
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		var found bool
+		for j, column := range columns {
+			if strings.EqualFold(column, key) {
+				parts[i] = formatted(values[j])
+				found = true
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// get loads the object of the table from the cache, returning true if it was found
+func (c *cache) get(table string, o DBObject, key string) bool {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[table][key]
+	if ok {
+		entry := elem.Value.(*cacheEntry)
+		if c.ttl > 0 && time.Now().After(entry.expires) {
+			c.remove(elem)
+			c.stats.Evictions++
+			ok = false
+		} else if err := restoreValues(o, entry.values); err != nil {
+			c.remove(elem)
+			ok = false
+		} else {
+			c.lru.MoveToFront(elem)
+		}
+	}
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return ok
+}
+
+// generation returns the count of invalidations of the table, which
+// only grows, to be taken before reading an object to put in the cache
+func (c *cache) generation(table string) uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.invalidated[table] + c.cleared
+}
+
+// put adds the object loaded from the table to the cache, unless
+// the table was invalidated since the generation it was read in
+func (c *cache) put(table string, o DBObject, key string, generation uint64) {
+	values, err := copyValues(o)
+	if err != nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.invalidated[table]+c.cleared != generation {
+		return
+	}
+	if elem, ok := c.entries[table][key]; ok {
+		c.remove(elem)
+	}
+	entry := &cacheEntry{table: table, key: key, values: values}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	if c.entries[table] == nil {
+		c.entries[table] = make(map[string]*list.Element)
+	}
+	c.entries[table][key] = c.lru.PushFront(entry)
+	for c.size > 0 && c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the objects of the table
+func (c *cache) invalidate(table string) {
+	c.Lock()
+	defer c.Unlock()
+	for _, elem := range c.entries[table] {
+		c.lru.Remove(elem)
+	}
+	delete(c.entries, table)
+	c.invalidated[table]++
+}
+
+// clear drops all objects
+func (c *cache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]map[string]*list.Element)
+	c.cleared++
+}
+
+func (c *cache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries[entry.table], entry.key)
+}
+
+// invalidate drops the objects of the table from the cache, if any
+func (db RDB) invalidate(o DBObject) {
+	if db.cache != nil {
+		db.cache.invalidate(db.Table(o))
+	}
+}
+
+// copyValues returns copies of the values of the object's receivers,
+// so later changes to the object do not alter the cached values
+func copyValues(o DBObject) ([]interface{}, error) {
+	receivers := o.Receivers()
+	values := make([]interface{}, len(receivers))
+	for i, r := range receivers {
+		switch v := r.(type) {
+		case JSONField:
+			text, err := v.encode()
+			if err != nil {
+				return nil, err
+			}
+			values[i] = text
+		case *[]byte:
+			values[i] = append([]byte(nil), *v...)
+		default:
+			values[i] = received(r)
+		}
+	}
+	return values, nil
+}
+
+// restoreValues sets the object's receivers to the cached values
+func restoreValues(o DBObject, values []interface{}) error {
+	for i, r := range o.Receivers() {
+		switch v := r.(type) {
+		case JSONField:
+			// decoding into a zeroed member keeps maps from merging
+			if p := reflect.ValueOf(v.V); p.Kind() == reflect.Ptr && !p.IsNil() {
+				p.Elem().Set(reflect.Zero(p.Elem().Type()))
+			}
+			if err := v.decode(values[i].(string)); err != nil {
+				return err
+			}
+		case *[]byte:
+			*v = append([]byte(nil), values[i].([]byte)...)
+		default:
+			p := reflect.ValueOf(r)
+			if p.Kind() != reflect.Ptr || p.IsNil() {
+				continue
+			}
+			if values[i] == nil {
+				p.Elem().Set(reflect.Zero(p.Elem().Type()))
+			} else {
+				p.Elem().Set(reflect.ValueOf(values[i]))
+			}
+		}
+	}
+	return nil
+}
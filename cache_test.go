@@ -0,0 +1,125 @@
+package rqlobj
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	s := &testStruct{}
+	if key, ok := cacheKey(s, []string{"id"}, []interface{}{7}); !ok || key != "7" {
+		t.Errorf("expected key 7 but got %q %v", key, ok)
+	}
+	if _, ok := cacheKey(s, []string{"name"}, []interface{}{"seven"}); ok {
+		t.Errorf("objects loaded by other columns are not cached")
+	}
+	if _, ok := cacheKey(s, []string{"id", "name"}, []interface{}{7, "seven"}); ok {
+		t.Errorf("objects loaded by more than their keys are not cached")
+	}
+}
+
+func TestCache(t *testing.T) {
+	db := RDB{}.WithCache(2, 0)
+	c := db.cache
+	one := &testStruct{ID: 1, Name: "one", Kind: 1, Modified: time.Unix(1000, 0)}
+	c.put(tableName, one, "1", 0)
+	// the cached values do not change with the object
+	one.Name = "changed"
+	got := &testStruct{}
+	if !c.get(tableName, got, "1") {
+		t.Fatal("expected a hit")
+	}
+	if got.ID != 1 || got.Name != "one" || got.Kind != 1 || !got.Modified.Equal(time.Unix(1000, 0)) {
+		t.Errorf("bad cached object: %+v", got)
+	}
+	if c.get(tableName, got, "2") {
+		t.Errorf("expected a miss")
+	}
+	c.put(tableName, &testStruct{ID: 2}, "2", 0)
+	c.get(tableName, got, "1")
+	// the least recently used is evicted
+	c.put(tableName, &testStruct{ID: 3}, "3", 0)
+	if c.get(tableName, got, "2") {
+		t.Errorf("expected 2 to be evicted")
+	}
+	stats := db.CacheStats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("bad stats: %+v", stats)
+	}
+	db.invalidate(one)
+	if c.get(tableName, got, "1") || c.get(tableName, got, "3") || db.CacheStats().Entries != 0 {
+		t.Errorf("expected the table to be invalidated")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := RDB{}.WithCache(0, time.Millisecond).cache
+	c.put(tableName, &testStruct{ID: 1}, "1", 0)
+	time.Sleep(5 * time.Millisecond)
+	if c.get(tableName, &testStruct{}, "1") {
+		t.Errorf("expected the entry to expire")
+	}
+	if c.stats.Evictions != 1 {
+		t.Errorf("expired entry not counted: %+v", c.stats)
+	}
+}
+
+// jsonObject has a member stored as json
+type jsonObject struct {
+	testStruct
+	attrs map[string]string
+}
+
+func (o *jsonObject) Receivers() []interface{} {
+	return []interface{}{&o.ID, JSON(&o.attrs)}
+}
+
+func TestCacheJSON(t *testing.T) {
+	src := map[string]string{"a": "1"}
+	values, err := copyValues(&jsonObject{attrs: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src["b"] = "2"
+	dst := &jsonObject{attrs: map[string]string{"stale": "x"}}
+	if err := restoreValues(dst, values); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.attrs) != 1 || dst.attrs["a"] != "1" {
+		t.Errorf("bad restored json: %v", dst.attrs)
+	}
+}
+
+func TestCacheGeneration(t *testing.T) {
+	var queries int
+	var db RDB
+	writing := true
+	db = apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if writing {
+			// a write of the table lands while the object is read
+			db.invalidate(&testStruct{})
+		}
+		w.Write([]byte(`{"results": [{"columns": ["id", "name", "kind", "data", "modified"], "values": [[1, "one", 1, "", null]]}]}`))
+	})
+	db = db.WithCache(10, 0)
+	for i, want := range []int{1, 2, 3, 3} {
+		if i == 2 {
+			writing = false
+		}
+		if err := db.LoadBy(&testStruct{}, "id", 1); err != nil {
+			t.Fatal(err)
+		}
+		if queries != want {
+			t.Errorf("load %d: %d queries, want %d", i+1, queries, want)
+		}
+	}
+	c := db.cache
+	generation := c.generation(tableName)
+	c.clear()
+	c.put(tableName, &testStruct{ID: 2}, "2", generation)
+	if c.get(tableName, &testStruct{}, "2") {
+		t.Error("object read before the cache was cleared was cached")
+	}
+}
@@ -0,0 +1,245 @@
+package rqlobj
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// changesSuffix names the changelog of a table, as generated by dbgen
+const changesSuffix = "_changes"
+
+// WatchBatch is the most changes read from a changelog at a time
+const WatchBatch = 100
+
+// WatchInterval is how long Watch waits before polling a changelog
+// that had no more changes
+var WatchInterval = time.Second
+
+// ChangeLogger is implemented by objects whose changes are logged
+// by triggers on their table, as generated by dbgen
+type ChangeLogger interface {
+	// SQLChanges returns the queries to create the changelog and its triggers
+	SQLChanges() []string
+}
+
+// ChangeOp is the kind of a change
+type ChangeOp string
+
+const (
+	// ChangeInsert is an object added
+	ChangeInsert ChangeOp = "insert"
+
+	// ChangeUpdate is an object updated
+	ChangeUpdate ChangeOp = "update"
+
+	// ChangeDelete is an object deleted
+	ChangeDelete ChangeOp = "delete"
+)
+
+// Change is an insert, update or delete logged for a table
+type Change struct {
+	ID     int64         // position in the changelog, to resume watching after
+	Op     ChangeOp      // the kind of change
+	Keys   []interface{} // the key values of the object changed
+	Object DBObject      // the object as changed, of the watched type, nil if deleted
+	Time   time.Time     // when the change was logged, in UTC
+
+	// Err is set, and nothing else, when reading the changelog failed
+	Err error
+}
+
+// Changes returns up to limit changes to objects like o logged after sinceID,
+// which is zero to read from the start of the changelog. Only the changes to
+// the objects of the db's tenant are returned, if their type is scoped to tenants
+func (db RDB) Changes(o DBObject, sinceID int64, limit int) ([]Change, error) {
+	if _, ok := o.(ChangeLogger); !ok {
+		return nil, fmt.Errorf("%T has no changelog", o)
+	}
+	scope, err := db.changesScope(o)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.queryResult(changesQuery(db.Table(o), sinceID, limit, scope))
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for result.Next() {
+		var id int64
+		var op, keys, object, changed string
+		if err := scan(&result, &id, &op, &keys, &object, &changed); err != nil {
+			return changes, err
+		}
+		change, err := changeOf(o, id, op, keys, object, changed)
+		if err != nil {
+			return changes, fmt.Errorf("change %d: %w", id, err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Watch polls the changelog of objects like o, sending the changes logged
+// after sinceID on the returned channel in the order they were made, until
+// the context is done, when the channel is closed. Failures to read the
+// changelog are sent as changes with Err set, and polling continues.
+// The ID of the last change received resumes watching where it left off
+func (db RDB) Watch(ctx context.Context, o DBObject, sinceID int64) (<-chan Change, error) {
+	if _, ok := o.(ChangeLogger); !ok {
+		return nil, fmt.Errorf("%T has no changelog", o)
+	}
+	if _, err := db.changesScope(o); err != nil {
+		return nil, err
+	}
+	ch := make(chan Change)
+	go func() {
+		defer close(ch)
+		send := func(c Change) bool {
+			select {
+			case ch <- c:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			changes, err := db.Changes(o, sinceID, WatchBatch)
+			for _, c := range changes {
+				if !send(c) {
+					return
+				}
+				sinceID = c.ID
+			}
+			if err != nil && !send(Change{Err: err}) {
+				return
+			}
+			// keep reading while there is a backlog
+			if err == nil && len(changes) == WatchBatch {
+				continue
+			}
+			select {
+			case <-time.After(WatchInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// TrimChanges deletes the changes to objects like o logged up to and
+// including throughID, once every watcher has received them, leaving
+// those of other tenants if their type is scoped to tenants
+func (db RDB) TrimChanges(o DBObject, throughID int64) error {
+	if _, ok := o.(ChangeLogger); !ok {
+		return fmt.Errorf("%T has no changelog", o)
+	}
+	scope, err := db.changesScope(o)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("delete from %s where id <= %d", db.Table(o)+changesSuffix, throughID)
+	if scope != "" {
+		query += " and " + scope
+	}
+	_, err = db.Write(query)
+	return err
+}
+
+// changesQuery returns the query for the changes to the table logged
+// after sinceID, restricted by the scope, if any
+func changesQuery(table string, sinceID int64, limit int, scope string) string {
+	query := fmt.Sprintf("select id, op, keys, object, changed from %s where id > %d", table+changesSuffix, sinceID)
+	if scope != "" {
+		query += " and " + scope
+	}
+	query += " order by id"
+	if limit > 0 {
+		query += fmt.Sprintf(" limit %d", limit)
+	}
+	return query
+}
+
+// changeOf returns the change of a changelog row, decoding its keys
+// and object into a new object like o
+func changeOf(o DBObject, id int64, op, keys, object, changed string) (Change, error) {
+	change := Change{ID: id, Op: ChangeOp(op)}
+	var err error
+	if change.Time, err = toTime(changed); err != nil {
+		return change, err
+	}
+	obj := newLike(o)
+	var values []json.RawMessage
+	if err := json.Unmarshal([]byte(keys), &values); err != nil {
+		return change, fmt.Errorf("decoding keys: %w", err)
+	}
+	dest, err := columnReceivers(obj, o.KeyFields())
+	if err != nil {
+		return change, err
+	}
+	if len(values) != len(dest) {
+		return change, fmt.Errorf("logged %d keys for %d key fields", len(values), len(dest))
+	}
+	for i, raw := range values {
+		if err := changeValue(dest[i], raw); err != nil {
+			return change, fmt.Errorf("key %s: %w", o.KeyFields()[i], err)
+		}
+		change.Keys = append(change.Keys, received(dest[i]))
+	}
+	if change.Op == ChangeDelete || object == "" {
+		return change, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(object), &fields); err != nil {
+		return change, fmt.Errorf("decoding object: %w", err)
+	}
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		columns = append(columns, column)
+	}
+	if dest, err = columnReceivers(obj, columns); err != nil {
+		return change, err
+	}
+	for i, column := range columns {
+		if err := changeValue(dest[i], fields[column]); err != nil {
+			return change, fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+	snapshot(obj)
+	change.Object = obj
+	return change, nil
+}
+
+// changeValue sets the receiver to the value of a column logged as json
+func changeValue(dest interface{}, raw json.RawMessage) error {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if b, ok := dest.(*[]byte); ok {
+			// blobs are logged as hex
+			data, err := hex.DecodeString(v)
+			if err != nil {
+				return err
+			}
+			*b = data
+			return nil
+		}
+		return parseText(dest, v)
+	case float64:
+		// times are stored as unix timestamps
+		if t, ok := dest.(*time.Time); ok {
+			var err error
+			*t, err = toTime(v)
+			return err
+		}
+	}
+	return parseText(dest, string(raw))
+}
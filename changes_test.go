@@ -0,0 +1,123 @@
+package rqlobj
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// loggedStruct logs its changes, as generated by dbgen
+type loggedStruct struct {
+	testStruct
+}
+
+func (o *loggedStruct) SQLChanges() []string {
+	return nil
+}
+
+// tenantLoggedStruct logs the changes of the objects of its tenants
+type tenantLoggedStruct struct {
+	tenantStruct
+}
+
+func (o *tenantLoggedStruct) SQLChanges() []string {
+	return nil
+}
+
+func TestChangesQuery(t *testing.T) {
+	const query = "select id, op, keys, object, changed from test_structs_changes where id > 7 order by id limit 100"
+	if got := changesQuery(tableName, 7, WatchBatch, ""); got != query {
+		t.Errorf("got %s\nwant %s", got, query)
+	}
+	scope, err := RDB{}.ForTenant("acme").changesScope(&tenantLoggedStruct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const scoped = "select id, op, keys, object, changed from test_structs_changes where id > 7 and json_extract(object, '$.kind')='acme' order by id"
+	if got := changesQuery(tableName, 7, 0, scope); got != scoped {
+		t.Errorf("got %s\nwant %s", got, scoped)
+	}
+}
+
+func TestChangesUnscoped(t *testing.T) {
+	var db RDB
+	o := &tenantLoggedStruct{}
+	if _, err := db.Changes(o, 0, 0); err != ErrNoTenant {
+		t.Errorf("Changes: expected ErrNoTenant but got %v", err)
+	}
+	if _, err := db.Watch(context.Background(), o, 0); err != ErrNoTenant {
+		t.Errorf("Watch: expected ErrNoTenant but got %v", err)
+	}
+	if err := db.TrimChanges(o, 1); err != ErrNoTenant {
+		t.Errorf("TrimChanges: expected ErrNoTenant but got %v", err)
+	}
+}
+
+func TestChangeOf(t *testing.T) {
+	const object = `{"id":3,"name":"three","kind":2,"data":"{\"a\":1}","modified":1700000000}`
+	change, err := changeOf(&loggedStruct{}, 5, "update", "[3]", object, "2023-11-14 22:13:20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.ID != 5 || change.Op != ChangeUpdate {
+		t.Errorf("bad change: %+v", change)
+	}
+	if !change.Time.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("bad time: %v", change.Time)
+	}
+	if len(change.Keys) != 1 || change.Keys[0] != int64(3) {
+		t.Errorf("bad keys: %#v", change.Keys)
+	}
+	o, ok := change.Object.(*loggedStruct)
+	if !ok {
+		t.Fatalf("expected a *loggedStruct but got %T", change.Object)
+	}
+	want := &testStruct{ID: 3, Name: "three", Kind: 2, Data: `{"a":1}`, Modified: time.Unix(1700000000, 0)}
+	if err := want.equal(&o.testStruct); err != nil {
+		t.Error(err)
+	}
+
+	change, err = changeOf(&loggedStruct{}, 6, "delete", "[3]", "", "2023-11-14 22:13:21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Op != ChangeDelete || change.Object != nil || change.Keys[0] != int64(3) {
+		t.Errorf("bad delete: %+v", change)
+	}
+
+	if _, err := changeOf(&loggedStruct{}, 7, "insert", "[3]", `{"color":"red"}`, "2023-11-14 22:13:22"); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+	if _, err := changeOf(&loggedStruct{}, 8, "insert", "[3, 4]", "", "2023-11-14 22:13:23"); err == nil {
+		t.Error("expected an error for too many keys")
+	}
+}
+
+func TestChangeValue(t *testing.T) {
+	var b []byte
+	if err := changeValue(&b, []byte(`"0aff"`)); err != nil || len(b) != 2 || b[1] != 0xff {
+		t.Errorf("bad blob: %v (%v)", b, err)
+	}
+	var ok bool
+	if err := changeValue(&ok, []byte(`1`)); err != nil || !ok {
+		t.Errorf("bad bool: %v (%v)", ok, err)
+	}
+	var f float64
+	if err := changeValue(&f, []byte(`2.5`)); err != nil || f != 2.5 {
+		t.Errorf("bad float: %v (%v)", f, err)
+	}
+	var when time.Time
+	if err := changeValue(&when, []byte(`"2023-11-14 22:13:20"`)); err != nil || when.Unix() != 1700000000 {
+		t.Errorf("bad time: %v (%v)", when, err)
+	}
+	s := "unchanged"
+	if err := changeValue(&s, []byte(`null`)); err != nil || s != "unchanged" {
+		t.Errorf("null changed the value: %q (%v)", s, err)
+	}
+}
+
+func TestWatchUnlogged(t *testing.T) {
+	if _, err := (RDB{}).Watch(context.Background(), &testStruct{}, 0); err == nil {
+		t.Error("expected an error for an object without a changelog")
+	}
+}
@@ -0,0 +1,210 @@
+package rqlobj
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the state of the node connected to, from rqlite's /status endpoint
+type Status struct {
+	Version      string        // rqlite version
+	Commit       string        // rqlite build commit
+	NodeID       string        // raft id of the node
+	State        string        // raft state, e.g., Leader or Follower
+	Leader       string        // raft address of the leader
+	Term         uint64        // current raft term
+	AppliedIndex uint64        // last log index applied to the database
+	CommitIndex  uint64        // last log index committed by the cluster
+	LastLogIndex uint64        // last log index stored by the node
+	Uptime       time.Duration // how long the node has been running
+
+	// Raw is the full status, for the details not parsed
+	Raw map[string]interface{}
+}
+
+// IsLeader returns true if the node is the cluster leader
+func (s Status) IsLeader() bool {
+	return strings.EqualFold(s.State, "leader")
+}
+
+// Node is a member of the cluster, from rqlite's /nodes endpoint
+type Node struct {
+	ID        string        `json:"id"`
+	APIAddr   string        `json:"api_addr"`
+	Addr      string        `json:"addr"`
+	Voter     bool          `json:"voter"`
+	Reachable bool          `json:"reachable"`
+	Leader    bool          `json:"leader"`
+	Time      time.Duration `json:"-"` // how long the node took to respond
+	Error     string        `json:"error"`
+}
+
+// rawStatus is the part of the /status reply that is parsed. The layout
+// varies by rqlite version: numbers may be given as strings, and the
+// leader as an address or an object
+type rawStatus struct {
+	Build struct {
+		Version string `json:"version"`
+		Commit  string `json:"commit"`
+	} `json:"build"`
+	Node struct {
+		Uptime string `json:"uptime"`
+	} `json:"node"`
+	Store struct {
+		NodeID string          `json:"node_id"`
+		Leader json.RawMessage `json:"leader"`
+		Raft   struct {
+			State        string     `json:"state"`
+			Term         flexNumber `json:"term"`
+			AppliedIndex flexNumber `json:"applied_index"`
+			CommitIndex  flexNumber `json:"commit_index"`
+			LastLogIndex flexNumber `json:"last_log_index"`
+		} `json:"raft"`
+	} `json:"store"`
+}
+
+// flexNumber is a number that may be encoded as a string
+type flexNumber uint64
+
+func (n *flexNumber) UnmarshalJSON(b []byte) error {
+	text := strings.Trim(string(b), `"`)
+	if text == "" || text == "null" {
+		return nil
+	}
+	u, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = flexNumber(u)
+	return nil
+}
+
+// Status returns the state of the node connected to
+func (db RDB) Status() (Status, error) {
+	resp, err := db.apiRequest("GET", "/status", nil, nil, nil, false)
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Status{}, err
+	}
+	return parseStatus(b)
+}
+
+// parseStatus returns the status of the /status reply
+func parseStatus(b []byte) (Status, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return Status{}, fmt.Errorf("decoding status: %w", err)
+	}
+	var rs rawStatus
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return Status{}, fmt.Errorf("decoding status: %w", err)
+	}
+	status := Status{
+		Version:      rs.Build.Version,
+		Commit:       rs.Build.Commit,
+		NodeID:       rs.Store.NodeID,
+		State:        rs.Store.Raft.State,
+		Term:         uint64(rs.Store.Raft.Term),
+		AppliedIndex: uint64(rs.Store.Raft.AppliedIndex),
+		CommitIndex:  uint64(rs.Store.Raft.CommitIndex),
+		LastLogIndex: uint64(rs.Store.Raft.LastLogIndex),
+		Raw:          raw,
+	}
+	if len(rs.Store.Leader) > 0 {
+		var leader struct {
+			Addr string `json:"addr"`
+		}
+		if err := json.Unmarshal(rs.Store.Leader, &status.Leader); err != nil {
+			if err := json.Unmarshal(rs.Store.Leader, &leader); err == nil {
+				status.Leader = leader.Addr
+			}
+		}
+	}
+	if rs.Node.Uptime != "" {
+		status.Uptime, _ = time.ParseDuration(rs.Node.Uptime)
+	}
+	return status, nil
+}
+
+// Nodes returns the members of the cluster, as seen by the node connected to,
+// ordered by id
+func (db RDB) Nodes() ([]Node, error) {
+	resp, err := db.apiRequest("GET", "/nodes", nil, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodes(b)
+}
+
+// rawNode is a node of the /nodes reply, with its response time in seconds
+type rawNode struct {
+	Node
+	Time float64 `json:"time"`
+}
+
+// parseNodes returns the nodes of the /nodes reply, which is either a map
+// of node id to node, or a list of nodes under "nodes" in later versions
+func parseNodes(b []byte) ([]Node, error) {
+	var list struct {
+		Nodes []rawNode `json:"nodes"`
+	}
+	var byID map[string]json.RawMessage
+	if err := json.Unmarshal(b, &byID); err != nil {
+		return nil, fmt.Errorf("decoding nodes: %w", err)
+	}
+	var raw []rawNode
+	if _, ok := byID["nodes"]; ok {
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, fmt.Errorf("decoding nodes: %w", err)
+		}
+		raw = list.Nodes
+	} else {
+		for id, text := range byID {
+			var n rawNode
+			if err := json.Unmarshal(text, &n); err != nil {
+				return nil, fmt.Errorf("decoding node %s: %w", id, err)
+			}
+			if n.ID == "" {
+				n.ID = id
+			}
+			raw = append(raw, n)
+		}
+	}
+	nodes := make([]Node, len(raw))
+	for i, n := range raw {
+		nodes[i] = n.Node
+		nodes[i].Time = time.Duration(n.Time * float64(time.Second))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes, nil
+}
+
+// Leader returns the node that leads the cluster
+func (db RDB) Leader() (Node, error) {
+	nodes, err := db.Nodes()
+	if err != nil {
+		return Node{}, err
+	}
+	for _, n := range nodes {
+		if n.Leader {
+			return n, nil
+		}
+	}
+	return Node{}, ErrNoLeader
+}
@@ -0,0 +1,93 @@
+package rqlobj
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+const statusReply = `{
+  "build": {"version": "v5.4.0", "commit": "abc123"},
+  "node": {"uptime": "1h2m3s"},
+  "store": {
+    "node_id": "node1",
+    "leader": "10.0.0.1:4002",
+    "raft": {"state": "Leader", "term": "3", "applied_index": 42, "commit_index": "42", "last_log_index": 43}
+  }
+}`
+
+func TestParseStatus(t *testing.T) {
+	status, err := parseStatus([]byte(statusReply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != "v5.4.0" || status.NodeID != "node1" || !status.IsLeader() || status.Leader != "10.0.0.1:4002" {
+		t.Errorf("bad status: %+v", status)
+	}
+	if status.Term != 3 || status.AppliedIndex != 42 || status.CommitIndex != 42 || status.LastLogIndex != 43 {
+		t.Errorf("bad raft indexes: %+v", status)
+	}
+	if status.Uptime != time.Hour+2*time.Minute+3*time.Second {
+		t.Errorf("bad uptime: %v", status.Uptime)
+	}
+	if status.Raw["build"] == nil {
+		t.Errorf("raw status is missing")
+	}
+	// later versions give the leader as an object
+	status, err = parseStatus([]byte(`{"store": {"leader": {"addr": "10.0.0.2:4002", "node_id": "node2"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Leader != "10.0.0.2:4002" {
+		t.Errorf("bad leader: %q", status.Leader)
+	}
+}
+
+func TestParseNodes(t *testing.T) {
+	const byID = `{
+  "node2": {"api_addr": "http://10.0.0.2:4001", "addr": "10.0.0.2:4002", "reachable": false, "leader": false, "error": "timeout"},
+  "node1": {"api_addr": "http://10.0.0.1:4001", "addr": "10.0.0.1:4002", "reachable": true, "leader": true, "time": 0.5}
+}`
+	const list = `{"nodes": [
+  {"id": "node1", "api_addr": "http://10.0.0.1:4001", "addr": "10.0.0.1:4002", "voter": true, "reachable": true, "leader": true, "time": 0.5},
+  {"id": "node2", "api_addr": "http://10.0.0.2:4001", "addr": "10.0.0.2:4002", "reachable": false, "error": "timeout"}
+]}`
+	for _, reply := range []string{byID, list} {
+		nodes, err := parseNodes([]byte(reply))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 2 {
+			t.Fatalf("expected 2 nodes but got %d", len(nodes))
+		}
+		if n := nodes[0]; n.ID != "node1" || !n.Leader || !n.Reachable || n.Time != time.Second/2 {
+			t.Errorf("bad leader node: %+v", n)
+		}
+		if n := nodes[1]; n.ID != "node2" || n.Reachable || n.Error != "timeout" {
+			t.Errorf("bad unreachable node: %+v", n)
+		}
+	}
+}
+
+func TestLeader(t *testing.T) {
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nodes":
+			w.Write([]byte(`{"node1": {"api_addr": "http://10.0.0.1:4001", "leader": true, "reachable": true}}`))
+		case "/status":
+			w.Write([]byte(statusReply))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	leader, err := db.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader.ID != "node1" || leader.APIAddr != "http://10.0.0.1:4001" {
+		t.Errorf("bad leader: %+v", leader)
+	}
+	if status, err := db.Status(); err != nil || status.NodeID != "node1" {
+		t.Errorf("bad status: %+v %v", status, err)
+	}
+}
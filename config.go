@@ -0,0 +1,94 @@
+package rqlobj
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// defaultPort is the port of rqlite's http api, when the url has none
+const defaultPort = "4001"
+
+// Config is the configuration of a connection to a rqlite cluster
+type Config struct {
+	// URL is the address of a node of the cluster, e.g., https://rqlite1:4001,
+	// which may include the read consistency level, e.g., ?level=strong
+	URL string
+
+	// Username and Password are the basic auth credentials of the cluster,
+	// replacing any in the URL
+	Username string
+	Password string
+
+	// Client makes every request to the cluster. Its transport configures
+	// TLS, e.g., the CAs trusted and the client certificates presented,
+	// proxies and connection pooling, and its timeout limits each request.
+	// Redirects to the leader are followed by the db rather than by the
+	// client's CheckRedirect. The default client is used if nil
+	Client *http.Client
+
+	// Logger receives warnings and, when debugging, the queries made.
+	// Warnings go to the standard logger if nil
+	Logger io.Writer
+
+	// Trace receives each request made, with its status and duration, if not nil
+	Trace io.Writer
+}
+
+// NewRqliteConfig returns a RDB connected to a rqlite cluster as configured
+func NewRqliteConfig(cfg Config) (RDB, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = ioutil.Discard
+	}
+	dbu := RDB{_log: log.New(logger, "", 0), client: cfg.Client, leader: &leaderCache{}}
+	// warnings are not discarded along with the debugging output
+	warn := log.Printf
+	if cfg.Logger != nil {
+		warn = dbu._log.Printf
+	}
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return dbu, fmt.Errorf("parsing url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return dbu, fmt.Errorf("url scheme %q is not http or https", base.Scheme)
+	}
+	if cfg.Username != "" || cfg.Password != "" {
+		base.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	base.Host = nodeHost(base.Host)
+	dbu.level = base.Query().Get("level")
+	base.RawQuery = ""
+	dbu.base = base
+	if cfg.Trace != nil {
+		dbu.trace = log.New(cfg.Trace, "", log.LstdFlags)
+		dbu.debug = true
+	}
+	// the node must be reachable
+	if _, err := dbu.Status(); err != nil {
+		return dbu, err
+	}
+	// rqlite only enforces foreign keys when started with -fk
+	if on, err := dbu.ForeignKeys(); err != nil {
+		warn("checking foreign key enforcement: %v\n", err)
+	} else if !on {
+		warn("warning: foreign keys are not enforced, start rqlited with -fk\n")
+	}
+	return dbu, nil
+}
+
+// nodeHost returns the host and port of a node, given its host
+func nodeHost(host string) string {
+	if host == "" {
+		return net.JoinHostPort("localhost", defaultPort)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(host, defaultPort)
+	}
+	return host
+}
@@ -0,0 +1,147 @@
+package rqlobj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tlsCluster returns a node with a self signed certificate that requires
+// basic auth, which redirects the writes it is sent to the path /leader
+func tlsCluster(t *testing.T, written *[]string) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/status":
+			fmt.Fprint(w, `{"store": {"leader": "raft1"}}`)
+		case "/db/query":
+			fmt.Fprint(w, `{"results": [{"columns": ["foreign_keys"], "types": ["integer"], "values": [[1]], "time": 0.001}]}`)
+		case "/db/execute":
+			http.Redirect(w, r, server.URL+"/leader"+r.URL.RequestURI(), http.StatusMovedPermanently)
+		case "/leader/db/execute":
+			if r.Method != "POST" {
+				http.Error(w, "not a post", http.StatusMethodNotAllowed)
+				return
+			}
+			var statements []string
+			if err := json.NewDecoder(r.Body).Decode(&statements); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*written = append(*written, statements...)
+			fmt.Fprint(w, `{"results": [{"last_insert_id": 7, "rows_affected": 1, "time": 0.001}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewRqliteConfig(t *testing.T) {
+	var written []string
+	server := tlsCluster(t, &written)
+
+	// the server's certificate is only trusted by its client
+	db, err := NewRqliteConfig(Config{
+		URL:      server.URL,
+		Username: "admin",
+		Password: "secret",
+		Client:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if on, err := db.ForeignKeys(); err != nil || !on {
+		t.Fatalf("foreign keys not reported: %v (%v)", on, err)
+	}
+	results, err := db.Write("insert into t values (1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != "insert into t values (1)" {
+		t.Errorf("write not redirected with its statements: %q", written)
+	}
+	if len(results) != 1 || results[0].LastInsertID != 7 || results[0].RowsAffected != 1 {
+		t.Errorf("bad results: %+v", results)
+	}
+
+	if _, err := NewRqliteConfig(Config{URL: server.URL, Client: server.Client()}); err == nil {
+		t.Error("expected connecting without credentials to fail")
+	}
+	if _, err := NewRqliteConfig(Config{URL: server.URL, Username: "admin", Password: "secret"}); err == nil {
+		t.Error("expected connecting with a client not trusting the server to fail")
+	}
+}
+
+func TestForeignKeysWarning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			fmt.Fprint(w, `{"store": {"leader": "raft1"}}`)
+		case "/db/query":
+			fmt.Fprint(w, `{"results": [{"columns": ["foreign_keys"], "types": ["integer"], "values": [[0]]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	const warning = "foreign keys are not enforced"
+
+	// without a logger, the warning goes to the standard logger
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+	if _, err := NewRqliteConfig(Config{URL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(std.String(), warning) {
+		t.Errorf("warning not logged: %q", std.String())
+	}
+
+	var logged bytes.Buffer
+	std.Reset()
+	if _, err := NewRqliteConfig(Config{URL: server.URL, Logger: &logged}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged.String(), warning) || std.Len() > 0 {
+		t.Errorf("warning not logged by the logger: %q (standard logger: %q)", logged.String(), std.String())
+	}
+}
+
+func TestNodeHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"":               "localhost:4001",
+		"rbox1":          "rbox1:4001",
+		"rbox1:4003":     "rbox1:4003",
+		"127.0.0.1:4001": "127.0.0.1:4001",
+	} {
+		if got := nodeHost(host); got != want {
+			t.Errorf("host %q: got %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestAPIHost(t *testing.T) {
+	for addr, want := range map[string]string{
+		"rbox1:4001":          "rbox1:4001",
+		"127.0.0.1:4001":      "127.0.0.1:4001",
+		"http://rbox1:4001":   "rbox1:4001",
+		"https://10.0.0.1:80": "10.0.0.1:80",
+	} {
+		if got := apiHost(addr); got != want {
+			t.Errorf("addr %q: got %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -37,6 +37,77 @@
 //
 //	//go:generate dbgen
 //
+// Options may follow the column name in the sql tag, separated by commas:
+//
+//	key	the column is (part of) the primary key
+//	json	the member (struct, map or slice) is stored as JSON text
+//	autoincrement	the int64 key is never reused
+//	notnull	the column may not be null
+//	unique	the column values must be unique
+//
+// Tables with more than one key have a composite primary key. Options may
+// follow the table name as well, e.g., `table:"hosts,strict,withoutrowid"`
+// for STRICT and WITHOUT ROWID tables. Times are integers in STRICT tables,
+// as unix seconds, and their defaults are converted to match.
+//
+// Related objects are declared with rel tags on members without sql tags,
+// naming the foreign key column, e.g.,
+//
+//	Hosts []Host `rel:"has_many,site_id"`  // hosts.site_id references this
+//	Site  *Site  `rel:"belongs_to,site_id"` // this site_id references a site
+//
+// and are loaded for a list of objects with RDB.Preload, or for a single
+// object with the generated Load<Member> method.
+//
+// Objects associated through a join table, which RDB.CreateTables creates
+// along with the tables of the objects, are declared as
+//
+//	Groups []Group `rel:"many_to_many,user_groups,user_id,group_id"`
+//
+// and linked with RDB.Link and RDB.Unlink.
+//
+// Foreign keys are declared with fk tags naming the referenced table and
+// column, optionally followed by the actions taken when the referenced row
+// is deleted or its key updated (cascade, set null, set default, restrict
+// or no action; updates cascade unless stated) and whether the constraint
+// is checked at the end of the transaction, e.g.,
+//
+//	SiteID int64 `sql:"site_id" fk:"sites(id),ondelete=cascade,deferrable"`
+//
+// Column constraints are declared with their own tags, e.g.,
+//
+//	Kind     int       `sql:"kind" check:"kind >= 0" notnull:"true"`
+//	Name     string    `sql:"name" collate:"nocase"`
+//	Created  time.Time `sql:"created" default:"datetime('now')"`
+//
+// Members with a default are left out when adding an object
+// with a zero value for them, so the database default applies.
+//
+// Types embedding rqlobj.Tracked get Snapshot and Changed methods,
+// so that RDB.Update only writes the columns changed since loading.
+//
+// The member tagged `tenant:"true"` holds the tenant of the object, so that
+// an RDB scoped with ForTenant only works with the objects of its tenant.
+//
+// Indexes are declared with index and unique tags. A value of "true" indexes
+// the column on its own, while naming an index (and optionally the column's
+// position within it) builds composite indexes, e.g., `index:"idx_name_kind,2"`.
+// Unnamed indexes are named idx_<table>_<column>, or uidx_<table>_<column> if unique.
+// The statements to create them are returned by the generated SQLIndexes method.
+//
+// String members tagged `fts:"true"` are indexed for full text search by an
+// FTS5 table named <table>_fts, kept in sync by triggers on the table and
+// queried with RDB.Search. This requires a single int64 key.
+//
+// The inserts, updates and deletes of tables with the changes option, e.g.,
+// `table:"hosts,changes"`, are logged by triggers to a table named
+// <table>_changes, which RDB.Watch follows. The tenant of deleted objects is
+// logged, so that an RDB scoped with ForTenant only follows its own changes.
+//
+// Column types follow the member type: bool and integer types are stored
+// as integer, float32 and float64 as real, []byte as blob, time.Time as
+// datetime and everything else as text.
+//
 // The -type flag accepts a comma-separated list of types so a single run can
 // generate methods for multiple types. The default output file is db_generated.go,
 // where t is the lower-cased name of the first type listed. It can be overridden
@@ -61,6 +132,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -74,11 +146,13 @@ var (
 	output    = flag.String("output", "", "output file name; default is "+generatedFile)
 	prefix    = flag.String("prefix", "", "only convert types with the given prefix")
 	verbose   = flag.Bool("verbose", false, "show processing info")
+	jsonCheck = flag.Bool("jsoncheck", false, "add a json_valid check constraint to json columns")
 )
 
 const (
 	generatedFile = "db_generated.go"
 	ignore        = "github.com/paulstuart/rqlobj.DBObject"
+	rqlobjPkg     = "github.com/paulstuart/rqlobj"
 	tagDefault    = "sql"
 )
 
@@ -109,18 +183,37 @@ Flags:
 }
 
 type SQLInfo struct {
-	Name      string              // type name
-	Table     string              // sql table
-	KeyNames  []string            // member name for key
-	KeyFields []string            // sql field for key
-	UserField string              // sql field for user id
-	TimeField string              // sql field for timestamp
-	Order     []string            // sql fields in order
-	Types     []string            // data types in order
-	Fields    map[string]string   // map of struct tag to column name
-	NoUpdate  map[string]struct{} // set of fields that should not be updated
-	Primary   bool                // there is one key and it is an int64
-	FK        map[string]string   // foreign key: field -> table(field)
+	Name      string                 // type name
+	Table     string                 // sql table
+	KeyNames  []string               // member name for key
+	KeyFields []string               // sql field for key
+	UserField string                 // sql field for user id
+	TimeField string                 // sql field for timestamp
+	Order     []string               // sql fields in order
+	Types     []string               // data types in order
+	ColTypes  map[string]string      // data type: field -> type
+	Indexes   []*Index               // indexes in order of declaration
+	AutoInc   bool                   // the primary key is autoincrement
+	Relations []*Relation            // related objects loaded by RDB.Preload
+	Options   []string               // table options, e.g., STRICT
+	Fields    map[string]string      // map of struct tag to column name
+	NoUpdate  map[string]struct{}    // set of fields that should not be updated
+	Primary   bool                   // there is one key and it is an int64
+	FK        map[string]*ForeignKey // foreign key: field -> reference
+	JSON      map[string]struct{}    // set of members stored as json text
+	Columns   map[string]*Field      // column constraints: field -> constraints
+	Tracked   bool                   // changes to fields are tracked
+	FTS       []string               // columns indexed for full text search
+	Changes   bool                   // changes are logged for RDB.Watch
+	Tenant    string                 // column holding the tenant of the object
+	Invalid   []string               // invalid declarations, which fail the generation
+}
+
+// invalidf logs and records an invalid declaration of the type
+func (s *SQLInfo) invalidf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("type: %s -- %s\n", s.Name, msg)
+	s.Invalid = append(s.Invalid, msg)
 }
 
 func main() {
@@ -149,24 +242,31 @@ func main() {
 		g.parsePackageFiles(args)
 	}
 
-	// Print the header and package clause.
-	var cmdargs string
-	if len(os.Args) > 1 {
-		cmdargs = " " + strings.Join(os.Args[1:], " ")
-	}
-	g.Printf("// generated by '%s%s'; DO NOT EDIT\n", path.Base(os.Args[0]), cmdargs)
-	g.Printf("\npackage %s\n", g.pkg.name)
-
+	valid := true
 	if len(names) == 0 {
-		g.generate("")
+		valid = g.generate("")
 	} else {
 		for _, typeName := range names {
-			g.generate(typeName)
+			valid = g.generate(typeName) && valid
 		}
 	}
+	if !valid {
+		log.Fatal("invalid declarations, no output written")
+	}
+
+	// Print the header, package clause and imports ahead of the generated code.
+	var cmdargs string
+	if len(os.Args) > 1 {
+		cmdargs = " " + strings.Join(os.Args[1:], " ")
+	}
+	header := fmt.Sprintf("// generated by '%s%s'; DO NOT EDIT\n", path.Base(os.Args[0]), cmdargs)
+	header += fmt.Sprintf("\npackage %s\n", g.pkg.name)
+	for _, imp := range g.importList() {
+		header += fmt.Sprintf("\nimport %q\n", imp)
+	}
 
 	// go fmt the output.
-	src := g.format()
+	src := g.format(header)
 
 	// Write to file.
 	outputName := *output
@@ -193,14 +293,33 @@ func isDirectory(name string) bool {
 // the output for format.Source.
 // sql tag added for testing
 type Generator struct {
-	buf bytes.Buffer `sql:"buf" table:"generator"` // Accumulated output.
-	pkg *Package     // Package we are scanning.
+	buf     bytes.Buffer        `sql:"buf" table:"generator"` // Accumulated output.
+	pkg     *Package            // Package we are scanning.
+	imports map[string]struct{} // Packages referenced by the generated code.
 }
 
 func (g *Generator) Printf(format string, args ...interface{}) {
 	fmt.Fprintf(&g.buf, format, args...)
 }
 
+// addImport notes a package the generated code depends upon
+func (g *Generator) addImport(pkg string) {
+	if g.imports == nil {
+		g.imports = make(map[string]struct{})
+	}
+	g.imports[pkg] = struct{}{}
+}
+
+// importList returns the sorted list of packages to import
+func (g *Generator) importList() []string {
+	list := make([]string, 0, len(g.imports))
+	for pkg := range g.imports {
+		list = append(list, pkg)
+	}
+	sort.Strings(list)
+	return list
+}
+
 // File holds a single parsed file and associated data.
 type File struct {
 	pkg  *Package  // Package to which this file belongs.
@@ -334,30 +453,56 @@ func (pkg *Package) check(fs *token.FileSet, astFiles []*ast.File) {
 	pkg.typesPkg = typesPkg
 }
 
-// generate produces the DBObject methods for the named type.
+// generate produces the DBObject methods for the named type,
+// reporting whether its declarations were valid
 func (g *Generator) generate(typeName string) bool {
+	valid := true
 	for _, file := range g.pkg.files {
 		file.findName = typeName
+		file.values = nil
 		if file.file != nil {
 			ast.Inspect(file.file, file.genDecl)
 			for _, v := range file.values {
 				//fmt.Printf("GEN (%T): %+v\n", v, v)
+				if len(v.Invalid) > 0 {
+					valid = false
+				}
 				g.buildWrappers(v)
 			}
 		}
 	}
-	return false
+	return valid
 }
 
-// format returns the gofmt-ed contents of the Generator's buffer.
-func (g *Generator) format() []byte {
-	src, err := format.Source(g.buf.Bytes())
+// lookup returns the sql info of the named type in the package, if any
+func (g *Generator) lookup(typeName string) *SQLInfo {
+	if g.pkg == nil {
+		return nil
+	}
+	for _, file := range g.pkg.files {
+		if file.file == nil {
+			continue
+		}
+		f := &File{pkg: file.pkg, file: file.file, findName: typeName}
+		ast.Inspect(f.file, f.genDecl)
+		if len(f.values) > 0 {
+			return f.values[0]
+		}
+	}
+	return nil
+}
+
+// format returns the gofmt-ed contents of the Generator's buffer,
+// preceded by the given header.
+func (g *Generator) format(header string) []byte {
+	raw := append([]byte(header), g.buf.Bytes()...)
+	src, err := format.Source(raw)
 	if err != nil {
 		// Should never happen, but can arise when developing this code.
 		// The user can compile the output to see the error.
 		log.Printf("warning: internal error: invalid Go generated: %s", err)
 		log.Printf("warning: compile the package to analyze the error")
-		return g.buf.Bytes()
+		return raw
 	}
 	return src
 }
@@ -383,33 +528,46 @@ func sqlTags(typeName string, fields *ast.FieldList) *SQLInfo {
 	}
 	status("evaluating type %q for sql tags\n", typeName)
 	info := SQLInfo{
+		Name:     typeName,
 		Fields:   make(map[string]string), // [memberName]sqlName
 		Order:    make([]string, 0, len(fields.List)),
 		NoUpdate: make(map[string]struct{}),
-		FK:       make(map[string]string),
+		FK:       make(map[string]*ForeignKey),
+		JSON:     make(map[string]struct{}),
+		Columns:  make(map[string]*Field),
+		ColTypes: make(map[string]string),
 	}
 	good := false
 	for _, field := range fields.List {
+		// changes are tracked for types embedding rqlobj.Tracked
+		if len(field.Names) == 0 && types.ExprString(field.Type) == "rqlobj.Tracked" {
+			info.Tracked = true
+			continue
+		}
 		if t := field.Tag; t != nil {
 			name := string(field.Names[0].Name)
 			s := string(t.Value)
 			// the code uses backticks to metaquote, need to strip them whilst evaluating
 			tag := reflect.StructTag(s[1 : len(s)-1])
 			if sql := tag.Get(*tagName); sql != "" {
-				typ := fmt.Sprint(field.Type)
+				typ := types.ExprString(field.Type)
 				//fmt.Printf("FLD NAME: %q TYPE: %q\n", field.Names[0].Name, typ)
-				switch typ {
-				case "string":
-					info.Types = append(info.Types, "text")
-				case "&{time Time}":
-					info.Types = append(info.Types, "datetime")
-				case "int", "int64", "uint", "uint64":
-					info.Types = append(info.Types, "integer")
-				default:
-					info.Types = append(info.Types, "text")
-				}
+				info.Types = append(info.Types, columnType(typ))
 				if table := tag.Get("table"); len(table) > 0 {
-					info.Table = table
+					parts := strings.Split(table, ",")
+					info.Table = parts[0]
+					for _, opt := range parts[1:] {
+						switch strings.ToLower(strings.TrimSpace(opt)) {
+						case "strict":
+							info.Options = append(info.Options, "STRICT")
+						case "withoutrowid", "without rowid":
+							info.Options = append(info.Options, "WITHOUT ROWID")
+						case "changes":
+							info.Changes = true
+						default:
+							info.invalidf("invalid table option: %s", opt)
+						}
+					}
 				}
 
 				// identify its name and if key field
@@ -419,16 +577,32 @@ func sqlTags(typeName string, fields *ast.FieldList) *SQLInfo {
 					sql = parts[0]
 					// using default "sql" for struct tags
 					if *tagName == tagDefault {
-						if parts[1] == "key" {
-							hasKey = true
-							const msg = "type: %s field: %s has is a key\n"
-							status(msg, name, sql)
-						} else {
-							log.Println("invalid option following field name:", parts[1])
-							// TODO: any more effort to transmit the error? Panic?
+						for _, opt := range parts[1:] {
+							switch opt {
+							case "key":
+								hasKey = true
+								const msg = "type: %s field: %s has is a key\n"
+								status(msg, name, sql)
+							case "autoincrement":
+								info.AutoInc = true
+							case "notnull":
+								info.column(sql).NotNull = true
+							case "unique":
+								info.column(sql).Unique = true
+							case "json":
+								// stored as json text, regardless of its type
+								info.Types[len(info.Types)-1] = "text"
+								info.JSON[name] = struct{}{}
+								if *jsonCheck {
+									info.column(sql).addCheck("json_valid(" + sql + ")")
+								}
+							default:
+								info.invalidf("field: %s -- invalid option following field name: %s", name, opt)
+							}
 						}
 					}
 				}
+				info.ColTypes[sql] = info.Types[len(info.Types)-1]
 				if !hasKey {
 					// if not using "sql" as the struct tag,
 					// a fallback option of using key=true,
@@ -459,14 +633,59 @@ func sqlTags(typeName string, fields *ast.FieldList) *SQLInfo {
 					info.Order = append(info.Order, name)
 					//info.Types = append(info.Types, field.Type)
 				}
+				// look for column constraints
+				if value := tag.Get("default"); value != "" {
+					info.column(sql).Default = value
+				}
+				if value := tag.Get("check"); value != "" {
+					info.column(sql).addCheck(value)
+				}
+				if value := tag.Get("collate"); value != "" {
+					info.column(sql).Collate = value
+				}
+				if value := tag.Get("notnull"); value != "" {
+					info.column(sql).NotNull, _ = strconv.ParseBool(value)
+				}
+				// look for index declarations
+				for _, kind := range []string{"index", "unique"} {
+					if value := tag.Get(kind); value != "" {
+						for _, ref := range indexRefs(value) {
+							info.addIndex(ref, sql, kind == "unique")
+						}
+					}
+				}
+				// look for full text search columns
+				if value := tag.Get("fts"); value != "" {
+					if on, _ := strconv.ParseBool(value); on && typ != "string" {
+						log.Printf("type: %s field: %s -- full text search requires a string\n", typeName, name)
+					} else if on {
+						info.FTS = append(info.FTS, sql)
+					}
+				}
+				// look for the tenant column
+				if value := tag.Get("tenant"); value != "" {
+					if on, _ := strconv.ParseBool(value); on && info.Tenant != "" {
+						log.Printf("type: %s field: %s -- tenant is already held by %s\n", typeName, name, info.Tenant)
+					} else if on {
+						info.Tenant = sql
+					}
+				}
 				// look for foreign key declarations
 				if fk := tag.Get("fk"); fk != "" {
 					const msg = "type: %s field: %s has foreign key: %s\n"
 					status(msg, name, sql, fk)
-					info.FK[sql] = fk
+					if ref := parseForeignKey(name, fk); ref != nil {
+						info.FK[sql] = ref
+					}
 				}
 				good = true
 			}
+			// look for related objects
+			if rel := tag.Get("rel"); rel != "" {
+				if r := parseRelation(name, types.ExprString(field.Type), rel); r != nil {
+					info.Relations = append(info.Relations, r)
+				}
+			}
 			// note fields to be excluded from object update queries
 			if update := tag.Get("update"); len(update) > 0 {
 				if up, err := strconv.ParseBool(update); err == nil && up == false {
@@ -475,6 +694,47 @@ func sqlTags(typeName string, fields *ast.FieldList) *SQLInfo {
 			}
 		}
 	}
+	for _, r := range info.Relations {
+		// the key of a related parent is taken from the foreign key, if declared
+		if !r.HasMany && r.Key == "" {
+			if fk, ok := info.FK[r.Column]; ok {
+				r.Key = fk.Column
+			}
+		}
+	}
+	if info.AutoInc && !info.Primary {
+		log.Printf("type: %s -- autoincrement requires a single int64 key\n", typeName)
+		info.AutoInc = false
+	}
+	if len(info.FTS) > 0 && !info.Primary {
+		// the index is keyed by the rowid, which is the int64 key
+		log.Printf("type: %s -- full text search requires a single int64 key\n", typeName)
+		info.FTS = nil
+	}
+	if info.Changes && len(info.KeyFields) == 0 {
+		log.Printf("type: %s -- logging changes requires a key\n", typeName)
+		info.Changes = false
+	}
+	for _, opt := range info.Options {
+		if opt == "WITHOUT ROWID" && len(info.KeyFields) == 0 {
+			info.invalidf("without rowid requires a primary key")
+		}
+	}
+	if info.AutoInc && within("WITHOUT ROWID", info.Options) {
+		log.Printf("type: %s -- autoincrement is not allowed without rowid\n", typeName)
+		info.AutoInc = false
+	}
+	for _, index := range info.Indexes {
+		if index.Name == "" {
+			// default names are derived from the table and first column,
+			// apart for unique indexes so a column can have both
+			prefix := "idx_"
+			if index.Unique {
+				prefix = "uidx_"
+			}
+			index.Name = prefix + info.Table + "_" + index.Columns()[0]
+		}
+	}
 	if good {
 		//fmt.Printf("INFO: %+v\n", info)
 		return &info
@@ -482,6 +742,348 @@ func sqlTags(typeName string, fields *ast.FieldList) *SQLInfo {
 	return nil
 }
 
+// ForeignKey is a column reference built from an fk tag
+type ForeignKey struct {
+	Ref        string // the referenced table and column, e.g., artist(artistid)
+	Column     string // the referenced column, if named
+	OnDelete   string // action when the referenced row is deleted
+	OnUpdate   string // action when the referenced key is updated
+	Deferrable string // when a deferrable constraint is checked
+}
+
+// fkActions are the actions allowed on delete and update
+var fkActions = []string{"CASCADE", "SET NULL", "SET DEFAULT", "RESTRICT", "NO ACTION"}
+
+// parseForeignKey parses an fk tag, which is the referenced table and column
+// optionally followed by the actions taken when the referenced row changes,
+// and whether the constraint is deferred, e.g.,
+// `fk:"sites(id),ondelete=set null,onupdate=cascade,deferrable"`.
+// Without an onupdate action, updates cascade
+func parseForeignKey(member, value string) *ForeignKey {
+	parts := strings.Split(value, ",")
+	fk := &ForeignKey{
+		Ref:      strings.TrimSpace(parts[0]),
+		OnUpdate: "CASCADE",
+	}
+	if fk.Ref == "" {
+		log.Printf("member: %s -- foreign key has no reference: %q\n", member, value)
+		return nil
+	}
+	if i := strings.Index(fk.Ref, "("); i > 0 && strings.HasSuffix(fk.Ref, ")") {
+		fk.Column = strings.TrimSpace(fk.Ref[i+1 : len(fk.Ref)-1])
+	}
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		var arg string
+		if len(kv) == 2 {
+			arg = strings.ToUpper(strings.Join(strings.Fields(kv[1]), " "))
+		}
+		switch kv[0] {
+		case "ondelete", "onupdate":
+			if !within(arg, fkActions) {
+				log.Printf("member: %s -- invalid foreign key action: %q\n", member, opt)
+				continue
+			}
+			if kv[0] == "ondelete" {
+				fk.OnDelete = arg
+			} else {
+				fk.OnUpdate = arg
+			}
+		case "deferrable":
+			switch arg {
+			case "", "DEFERRED":
+				fk.Deferrable = "DEFERRED"
+			case "IMMEDIATE":
+				fk.Deferrable = "IMMEDIATE"
+			default:
+				log.Printf("member: %s -- invalid deferrable option: %q\n", member, opt)
+			}
+		default:
+			log.Printf("member: %s -- invalid foreign key option: %q\n", member, opt)
+		}
+	}
+	return fk
+}
+
+// SQL returns the foreign key clause of the column definition
+func (fk *ForeignKey) SQL() string {
+	clause := " REFERENCES " + fk.Ref
+	if fk.OnDelete != "" {
+		clause += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		clause += " ON UPDATE " + fk.OnUpdate
+	}
+	if fk.Deferrable != "" {
+		clause += " DEFERRABLE INITIALLY " + fk.Deferrable
+	}
+	return clause
+}
+
+// Index is a table index built from index or unique tags
+type Index struct {
+	Name   string
+	Unique bool
+	fields []indexField
+}
+
+type indexField struct {
+	pos   int
+	field string
+}
+
+// Columns returns the indexed columns in order of their position
+func (x *Index) Columns() []string {
+	fields := make([]indexField, len(x.fields))
+	copy(fields, x.fields)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].pos < fields[j].pos
+	})
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = f.field
+	}
+	return columns
+}
+
+// SQL returns the statement to create the index on the table
+func (x *Index) SQL(table string) string {
+	var unique string
+	if x.Unique {
+		unique = "unique "
+	}
+	const text = "create %sindex if not exists %s on %s (%s)"
+	return fmt.Sprintf(text, unique, x.Name, table, strings.Join(x.Columns(), ", "))
+}
+
+// indexRef is a reference to an index by a field tag
+type indexRef struct {
+	name string
+	pos  int
+}
+
+// indexRefs parses the value of an index or unique tag. The value is "true"
+// for an index of its own, or a name with an optional position for composite
+// indexes, e.g., `index:"idx_name_kind,2"`. Several are separated by ";"
+func indexRefs(value string) []indexRef {
+	var refs []indexRef
+	for _, item := range strings.Split(value, ";") {
+		parts := strings.Split(strings.TrimSpace(item), ",")
+		ref := indexRef{name: strings.TrimSpace(parts[0])}
+		if ok, err := strconv.ParseBool(ref.name); err == nil {
+			if !ok {
+				continue
+			}
+			ref.name = ""
+		}
+		if len(parts) > 1 {
+			pos, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				log.Println("invalid index position:", item)
+			}
+			ref.pos = pos
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// addIndex adds the field to the referenced index
+func (s *SQLInfo) addIndex(ref indexRef, field string, unique bool) {
+	var index *Index
+	if ref.name != "" {
+		for _, x := range s.Indexes {
+			if x.Name == ref.name {
+				index = x
+				break
+			}
+		}
+	}
+	if index == nil {
+		index = &Index{Name: ref.name}
+		s.Indexes = append(s.Indexes, index)
+	}
+	index.Unique = index.Unique || unique
+	pos := ref.pos
+	if pos == 0 {
+		// unpositioned fields follow in the order they are declared
+		pos = len(index.fields) + 1
+	}
+	index.fields = append(index.fields, indexField{pos: pos, field: field})
+}
+
+// Relation is a member holding objects related by a foreign key
+type Relation struct {
+	Member  string // struct member holding the related objects
+	Type    string // type name of the related objects
+	HasMany bool   // a slice of objects referencing this one, otherwise the one referenced
+	Pointer bool   // the related objects are held by pointer
+	Column  string // the foreign key column
+	Key     string // the referenced column, if not the primary key
+	Join    *Join  // the association table of a many-to-many relation
+}
+
+// Join is the association table of a many-to-many relation
+type Join struct {
+	Table    string // name of the join table
+	Column   string // column referencing this object's key
+	Other    string // column referencing the related object's key
+	OnDelete string // foreign key action when either object is deleted
+}
+
+// SQL returns the statements to create the join table between the objects
+func (j *Join) SQL(this, other *SQLInfo) []string {
+	key := func(s *SQLInfo) (string, string, string) {
+		if s == nil || len(s.KeyFields) != 1 {
+			return "", "id", "integer"
+		}
+		return s.Table, s.KeyFields[0], s.ColTypes[s.KeyFields[0]]
+	}
+	thisTable, thisKey, thisType := key(this)
+	otherTable, otherKey, otherType := key(other)
+	const text = `create table if not exists %s (
+  %s %s not null REFERENCES %s(%s) ON DELETE %s,
+  %s %s not null REFERENCES %s(%s) ON DELETE %s,
+  PRIMARY KEY (%s, %s)
+) WITHOUT ROWID;`
+	create := fmt.Sprintf(text, j.Table,
+		j.Column, thisType, thisTable, thisKey, j.OnDelete,
+		j.Other, otherType, otherTable, otherKey, j.OnDelete,
+		j.Column, j.Other)
+	// the primary key covers lookups by this object, index the other way around
+	const index = "create index if not exists idx_%s_%s on %s (%s)"
+	return []string{create, fmt.Sprintf(index, j.Table, j.Other, j.Table, j.Other)}
+}
+
+// parseRelation parses a rel tag, which is either
+// `rel:"has_many,fk_column"` on a slice of the objects referencing this one, or
+// `rel:"belongs_to,fk_column"` on the object this one references by fk_column.
+// The referenced column may follow, if it is not the primary key.
+// Objects associated through a join table are declared on a slice with
+// `rel:"many_to_many,join_table,this_column,other_column"`, optionally followed
+// by the action taken on the join table when an object is deleted,
+// e.g., ondelete=restrict (the default is cascade)
+func parseRelation(member, typ, value string) *Relation {
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 {
+		log.Printf("member: %s -- relation requires a kind and a column: %q\n", member, value)
+		return nil
+	}
+	r := &Relation{
+		Member: member,
+		Column: strings.TrimSpace(parts[1]),
+	}
+	if len(parts) > 2 {
+		r.Key = strings.TrimSpace(parts[2])
+	}
+	switch strings.TrimSpace(parts[0]) {
+	case "has_many":
+		if !strings.HasPrefix(typ, "[]") {
+			log.Printf("member: %s -- has_many requires a slice, not %s\n", member, typ)
+			return nil
+		}
+		r.HasMany = true
+		typ = typ[2:]
+	case "belongs_to":
+	case "many_to_many":
+		if !strings.HasPrefix(typ, "[]") || len(parts) < 4 {
+			log.Printf("member: %s -- many_to_many requires a slice, join table and both columns: %q\n", member, value)
+			return nil
+		}
+		r.HasMany = true
+		typ = typ[2:]
+		r.Key = ""
+		r.Join = &Join{
+			Table:    r.Column,
+			Column:   strings.TrimSpace(parts[2]),
+			Other:    strings.TrimSpace(parts[3]),
+			OnDelete: "CASCADE",
+		}
+		r.Column = r.Join.Column
+		for _, opt := range parts[4:] {
+			kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+			if len(kv) == 2 && kv[0] == "ondelete" {
+				r.Join.OnDelete = strings.ToUpper(kv[1])
+			} else {
+				log.Printf("member: %s -- invalid relation option: %q\n", member, opt)
+			}
+		}
+	default:
+		log.Printf("member: %s -- invalid relation: %q\n", member, parts[0])
+		return nil
+	}
+	if strings.HasPrefix(typ, "*") {
+		r.Pointer = true
+		typ = typ[1:]
+	}
+	r.Type = typ
+	return r
+}
+
+// relationCode returns the rqlobj.Relation literal for the relation of the type
+func (g *Generator) relationCode(s *SQLInfo, r *Relation) string {
+	typeName := s.Name
+	var attach, reset, join string
+	related := "*x.(*" + r.Type + ")"
+	if r.Pointer {
+		related = "x.(*" + r.Type + ")"
+	}
+	if r.HasMany {
+		attach = fmt.Sprintf("o := p.(*%s); o.%s = append(o.%s, %s)", typeName, r.Member, r.Member, related)
+		reset = fmt.Sprintf("\n\t\tReset: func(p rqlobj.DBObject) { p.(*%s).%s = nil },", typeName, r.Member)
+	} else {
+		attach = fmt.Sprintf("p.(*%s).%s = %s", typeName, r.Member, related)
+	}
+	if j := r.Join; j != nil {
+		other := g.lookup(r.Type)
+		if other == nil {
+			log.Printf("type: %s -- related type %s not found, presuming an integer id key\n", typeName, r.Type)
+		}
+		var create strings.Builder
+		for _, query := range j.SQL(s, other) {
+			create.WriteString("\t\t\t\t" + strconv.Quote(query) + ",\n")
+		}
+		const text = `
+		Join: &rqlobj.JoinTable{
+			Table:  %q,
+			Column: %q,
+			Other:  %q,
+			Create: []string{
+%s			},
+		},`
+		join = fmt.Sprintf(text, j.Table, j.Column, j.Other, create.String())
+	}
+	const text = `{
+		Name:    %q,
+		HasMany: %t,
+		Column:  %q,
+		Key:     %q,
+		New:     func() rqlobj.DBObject { return new(%s) },
+		Attach:  func(p, x rqlobj.DBObject) { %s },%s%s
+	},
+`
+	return fmt.Sprintf(text, r.Member, r.HasMany, r.Column, r.Key, r.Type, attach, reset, join)
+}
+
+// columnType returns the sql column type for the go type
+func columnType(typ string) string {
+	switch typ {
+	case "string":
+		return "text"
+	case "time.Time":
+		return "datetime"
+	case "bool",
+		"int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64":
+		return "integer"
+	case "float32", "float64":
+		return "real"
+	case "[]byte", "[]uint8":
+		return "blob"
+	}
+	return "text"
+}
+
 // genDecl processes a declaration clause.
 func (f *File) genDecl(node ast.Node) bool {
 	switch x := node.(type) {
@@ -501,24 +1103,31 @@ func (f *File) genDecl(node ast.Node) bool {
 
 // buildWrappers generates the variables and String method for a single run of contiguous values.
 func (g *Generator) buildWrappers(s *SQLInfo) {
-	var insert_fields, names, elem, ptr, set, sql []string
-	keyField := make(map[string]struct{})
+	var insert_fields, insert_elem, names, elem, ptr, set, sql []string
 	// fields for sql keys and regular are presented seperately. join them.
 	sql = append(sql, s.KeyFields...)
-	for i, name := range s.KeyNames {
+	insert_fields = append(insert_fields, s.KeyFields...)
+	for _, name := range s.KeyNames {
 		ptr = append(ptr, "&o."+name)
-		keyField[s.KeyFields[i]] = struct{}{}
+		insert_elem = append(insert_elem, "o."+name)
 	}
 	for _, k := range s.Order {
 		if k != "" {
 			v := s.Fields[k]
 			sql = append(sql, v)
 			names = append(names, `"`+k+`"`)
-			elem = append(elem, "o."+k)
-			ptr = append(ptr, "&o."+k)
+			if _, ok := s.JSON[k]; ok {
+				g.addImport(rqlobjPkg)
+				elem = append(elem, "rqlobj.JSON(o."+k+")")
+				ptr = append(ptr, "rqlobj.JSON(&o."+k+")")
+			} else {
+				elem = append(elem, "o."+k)
+				ptr = append(ptr, "&o."+k)
+			}
 			set = append(set, v+"=?")
 			if _, ok := s.NoUpdate[v]; !ok {
 				insert_fields = append(insert_fields, v)
+				insert_elem = append(insert_elem, elem[len(elem)-1])
 			}
 		}
 	}
@@ -530,12 +1139,17 @@ func (g *Generator) buildWrappers(s *SQLInfo) {
 	} else {
 		g.Printf(metaPrimaryInvalid, s.Name)
 	}
-	g.Printf(metaInsertValues, s.Name, strings.Join(elem, ","))
+	g.Printf(metaInsertValues, s.Name, strings.Join(insert_elem, ","))
 	for _, name := range s.KeyNames {
 		elem = append(elem, "o."+name)
 	}
 	g.Printf(metaUpdateValues, s.Name, strings.Join(elem, ","))
 	g.Printf(metaReceivers, s.Name, strings.Join(ptr, ","))
+	columns := make([]string, len(sql))
+	for i, field := range sql {
+		columns[i] = fmt.Sprintf("\t\t%q: %s,\n", field, ptr[i])
+	}
+	g.Printf(metaColumnReceivers, s.Name, strings.Join(columns, ""))
 	kv := make([]string, len(s.KeyNames))
 	for i, name := range s.KeyNames {
 		kv[i] = "o." + name
@@ -563,21 +1177,194 @@ func (g *Generator) buildWrappers(s *SQLInfo) {
 	g.Printf(metaKeyNames, s.Name, keyNames)
 	g.Printf(metaElements, s.Name, qList(names))
 
-	// TODO: add support for default values <======================================================= SOON!
+	// column types are listed in the same order as the select fields
+	types := make([]string, len(sql))
+	for i, field := range sql {
+		types[i] = s.columnType(field)
+	}
+	g.Printf(metaSQLTypes, s.Name, quoteList(types))
 
-	g.Printf(metaSQLCreate, s.Name, s.Table, rowString(sql, s.Types, keyField, s.FK, s.Primary), "`")
+	var options string
+	if len(s.Options) > 0 {
+		options = " " + strings.Join(s.Options, ", ")
+	}
+	g.Printf(metaSQLCreate, s.Name, s.Table, rowString(s, sql, types), "`", options)
+	var defaults []string
+	for _, field := range insert_fields {
+		if col, ok := s.Columns[field]; ok && col.Default != "" {
+			defaults = append(defaults, field)
+		}
+	}
+	if len(defaults) > 0 {
+		g.Printf(metaDefaultFields, s.Name, quoteList(defaults))
+	}
+	if s.Tracked {
+		g.Printf(metaTracked, s.Name)
+	}
+	if s.Tenant != "" {
+		g.Printf(metaTenant, s.Name, s.Tenant)
+	}
+	if len(s.Relations) > 0 {
+		g.addImport(rqlobjPkg)
+		var relations strings.Builder
+		for _, r := range s.Relations {
+			relations.WriteString(g.relationCode(s, r))
+		}
+		g.Printf(metaRelations, s.Name, relations.String())
+		for _, r := range s.Relations {
+			g.Printf(metaLoadRelation, s.Name, r.Member)
+		}
+	}
+	if len(s.Indexes) > 0 {
+		queries := make([]string, len(s.Indexes))
+		for i, index := range s.Indexes {
+			queries[i] = strconv.Quote(index.SQL(s.Table)) + ",\n"
+		}
+		g.Printf(metaSQLIndexes, s.Name, strings.Join(queries, ""))
+	}
+	if len(s.FTS) > 0 {
+		queries := ftsSQL(s)
+		for i, query := range queries {
+			queries[i] = strconv.Quote(query) + ",\n"
+		}
+		g.Printf(metaSearch, s.Name, quoteList(s.FTS), strings.Join(queries, ""))
+	}
+	if s.Changes {
+		queries := changesSQL(s, sql)
+		for i, query := range queries {
+			queries[i] = strconv.Quote(query) + ",\n"
+		}
+		g.Printf(metaSQLChanges, s.Name, strings.Join(queries, ""))
+	}
 }
 
-// TODO: apply this struct for enhanced table generation
+// ftsSuffix names the full text index of a table, as expected by rqlobj
+const ftsSuffix = "_fts"
+
+// ftsSQL returns the statements to create the external content FTS5 table
+// indexing the full text search columns and the triggers keeping it in sync
+func ftsSQL(s *SQLInfo) []string {
+	fts := s.Table + ftsSuffix
+	key := s.KeyFields[0]
+	columns := strings.Join(s.FTS, ", ")
+	values := func(row string) string {
+		list := make([]string, len(s.FTS))
+		for i, column := range s.FTS {
+			list[i] = row + "." + column
+		}
+		return strings.Join(list, ", ")
+	}
+	insert := fmt.Sprintf("insert into %s(rowid, %s) values (new.%s, %s);", fts, columns, key, values("new"))
+	remove := fmt.Sprintf("insert into %s(%s, rowid, %s) values ('delete', old.%s, %s);", fts, fts, columns, key, values("old"))
+	const trigger = "create trigger if not exists %s_%s after %s on %s begin\n  %s\nend;"
+	return []string{
+		fmt.Sprintf("create virtual table if not exists %s using fts5(%s, content='%s', content_rowid='%s');", fts, columns, s.Table, key),
+		fmt.Sprintf(trigger, fts, "insert", "insert", s.Table, insert),
+		fmt.Sprintf(trigger, fts, "delete", "delete", s.Table, remove),
+		fmt.Sprintf(trigger, fts, "update", "update", s.Table, remove+"\n  "+insert),
+	}
+}
+
+// changesSuffix names the changelog of a table, as expected by rqlobj
+const changesSuffix = "_changes"
+
+// changesSQL returns the statements to create the changelog of the table
+// and the triggers logging the keys and new row of each change, as json.
+// Blobs are logged as hex, as json cannot hold them. Deletes log only the
+// tenant of the row, if any, so that changelogs can be read by tenant
+func changesSQL(s *SQLInfo, columns []string) []string {
+	changes := s.Table + changesSuffix
+	keys := func(row string) string {
+		list := make([]string, len(s.KeyFields))
+		for i, key := range s.KeyFields {
+			list[i] = row + "." + key
+		}
+		return "json_array(" + strings.Join(list, ", ") + ")"
+	}
+	pairs := make([]string, len(columns))
+	for i, column := range columns {
+		value := "new." + column
+		if s.ColTypes[column] == "blob" {
+			value = "hex(" + value + ")"
+		}
+		pairs[i] = fmt.Sprintf("'%s', %s", column, value)
+	}
+	object := "json_object(" + strings.Join(pairs, ", ") + ")"
+	deleted := "null"
+	if s.Tenant != "" {
+		deleted = fmt.Sprintf("json_object('%s', old.%s)", s.Tenant, s.Tenant)
+	}
+	const create = `create table if not exists %s (
+    id integer primary key autoincrement,
+    op text not null,
+    keys text not null,
+    object text,
+    changed datetime not null default current_timestamp
+);`
+	const trigger = "create trigger if not exists %s_%s after %s on %s begin\n  insert into %s (op, keys, object) values ('%s', %s, %s);\nend;"
+	return []string{
+		fmt.Sprintf(create, changes),
+		fmt.Sprintf(trigger, changes, "insert", "insert", s.Table, changes, "insert", keys("new"), object),
+		fmt.Sprintf(trigger, changes, "update", "update", s.Table, changes, "update", keys("new"), object),
+		fmt.Sprintf(trigger, changes, "delete", "delete", s.Table, changes, "delete", keys("old"), deleted),
+	}
+}
+
+// Field holds the constraints of a column
 type Field struct {
 	Check   string
 	Default string // make interface{} ?
 	Unique  bool
+	NotNull bool
+	Collate string
+}
+
+// addCheck adds the expression to the column's check constraint
+func (f *Field) addCheck(check string) {
+	if f.Check == "" {
+		f.Check = check
+		return
+	}
+	f.Check = "(" + f.Check + ") and (" + check + ")"
+}
+
+// column returns the constraints of the field, adding them if need be
+func (s *SQLInfo) column(field string) *Field {
+	col, ok := s.Columns[field]
+	if !ok {
+		col = new(Field)
+		s.Columns[field] = col
+	}
+	return col
+}
+
+// strict returns true if the table is a STRICT table
+func (s *SQLInfo) strict() bool {
+	return within("STRICT", s.Options)
+}
+
+// columnType returns the declared type of the column. STRICT tables
+// only allow the basic types, and times are stored as unix seconds
+func (s *SQLInfo) columnType(field string) string {
+	typ := s.ColTypes[field]
+	if typ == "datetime" && s.strict() {
+		return "integer"
+	}
+	return typ
+}
+
+// unixDefault returns the default of a time column of a STRICT table
+// as unix seconds, as the text of times, e.g., datetime('now'),
+// breaks the integer type of the column. Numbers are kept as is
+func unixDefault(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	return "cast(strftime('%s', " + value + ") as integer)"
 }
 
 // convert a list of column defs to a string
-// TODO: generate indexes for tables with multiple keys
-func rowString(fields, types []string, keys map[string]struct{}, fk map[string]string, primary bool) string {
+func rowString(s *SQLInfo, fields, types []string) string {
 	var buf strings.Builder
 	if len(fields) != len(types) {
 		const msg = "slice sizes don't match for fields:%d -- types:%d\n"
@@ -591,24 +1378,67 @@ func rowString(fields, types []string, keys map[string]struct{}, fk map[string]s
 		buf.WriteString(field)
 		buf.WriteString(" ")
 		buf.WriteString(types[i])
-		if _, ok := keys[field]; ok && len(keys) == 1 {
-			if (primary || len(keys) > 1) && i == 0 {
-				buf.WriteString(" primary key")
+		// multiple keys are declared as a table constraint
+		if len(s.KeyFields) == 1 && field == s.KeyFields[0] {
+			buf.WriteString(" primary key")
+			if s.AutoInc {
+				buf.WriteString(" autoincrement")
+			}
+		}
+		col, ok := s.Columns[field]
+		if !ok {
+			col = new(Field)
+		}
+		if col.NotNull {
+			buf.WriteString(" NOT NULL")
+		}
+		if col.Unique {
+			buf.WriteString(" UNIQUE")
+		}
+		if col.Collate != "" {
+			buf.WriteString(" COLLATE ")
+			buf.WriteString(col.Collate)
+		}
+		if col.Default != "" {
+			value := col.Default
+			if s.ColTypes[field] == "datetime" && s.strict() {
+				value = unixDefault(value)
 			}
+			// an expression in parentheses is valid for literals too
+			buf.WriteString(" DEFAULT (")
+			buf.WriteString(value)
+			buf.WriteString(")")
 		}
 		// foreign key support:
 		// fieldName fieldType  REFERENCES artist(artistid) ON UPDATE CASCADE;
-		if ref, ok := fk[field]; ok {
-			status("field: %s applying fk: %s\n", field, ref)
-			buf.WriteString(" REFERENCES ")
-			buf.WriteString(ref)
-			buf.WriteString(" ON UPDATE CASCADE")
+		if ref, ok := s.FK[field]; ok {
+			status("field: %s applying fk: %s\n", field, ref.Ref)
+			buf.WriteString(ref.SQL())
+		}
+		if col.Check != "" {
+			buf.WriteString(" CHECK (")
+			buf.WriteString(col.Check)
+			buf.WriteString(")")
 		}
 
 	}
+	if len(s.KeyFields) > 1 {
+		buf.WriteString(",\n  PRIMARY KEY (")
+		buf.WriteString(strings.Join(s.KeyFields, ", "))
+		buf.WriteString(")")
+	}
 	return buf.String()
 }
 
+func within(s string, list []string) bool {
+	for _, item := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
 func qList(list []string) string {
 	return strings.Join(list, ",")
 }
@@ -664,7 +1494,7 @@ const metaUpdate = `func (o *%[1]s) UpdateQuery() string {
 // Arguments to format are:
 //	[1]: type name
 //	[2]: sql table
-//	[3]: insert fields (excluding key)
+//	[3]: insert fields, keys first
 const metaInsertValues = `func (o *%[1]s) InsertValues() []interface{} {
 	return []interface{}{%s}
 }
@@ -691,6 +1521,17 @@ const metaReceivers = `func (o *%[1]s) Receivers() []interface{} {
 
 `
 
+// Arguments to format are:
+//	[1]: type name
+//	[2]: column receivers, e.g. "id": &o.ID,
+const metaColumnReceivers = `// ColumnReceivers returns the receivers of the select fields by column name
+func (o *%[1]s) ColumnReceivers() map[string]interface{} {
+	return map[string]interface{}{
+%[2]s	}
+}
+
+`
+
 // Arguments to format are:
 //	[1]: type name
 //	[2]: key fields, e.g. o.ID,o.Name,o.Kind
@@ -797,6 +1638,15 @@ const metaElements = `func (o *%[1]s) Elements() []string {
 
 `
 
+// Arguments to format are:
+//	[1]: type name
+//	[2]: column types
+const metaSQLTypes = `func (o *%[1]s) SQLTypes() []string {
+	return []string{%[2]s}
+}
+
+`
+
 // Arguments to format are:
 //	[1]: type name
 //	[2]: key member name
@@ -861,6 +1711,7 @@ func (o *%[1]ss) SQLResults(fn func(...interface{}) error) error {
 //	[2]: table name
 //	[3]: column declarations
 //	[4]: "`" to cheat at nesting quotes
+//	[5]: table options
 //			 1	 2	  3
 const metaSQLCreate = `
 
@@ -869,7 +1720,113 @@ const metaSQLCreate = `
 func (o *%[1]s) SQLCreate() string {
 	return %[4]screate table if not exists %[2]s (
 %[3]s
-);%[4]s
+)%[5]s;%[4]s
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: relation literals
+const metaRelations = `
+
+// Relations returns the objects related to the object, for RDB.Preload
+func (o *%[1]s) Relations() []rqlobj.Relation {
+	return []rqlobj.Relation{
+%[2]s}
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: relation member
+const metaLoadRelation = `
+
+// Load%[2]s loads the related %[2]s of the object
+func (o *%[1]s) Load%[2]s(db rqlobj.RDB) error {
+	return db.Preload(o, "%[2]s")
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: insert fields having default values
+const metaDefaultFields = `
+
+// DefaultFields returns the insert fields that have default values
+func (o *%[1]s) DefaultFields() []string {
+	return []string{%[2]s}
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+const metaTracked = `// Snapshot records the field values, for Changed to compare against
+func (o *%[1]s) Snapshot() {
+	o.Tracked.Record(o)
+}
+
+// Changed returns the columns of the fields modified since the Snapshot
+func (o *%[1]s) Changed() []string {
+	return o.Tracked.Compare(o)
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: tenant column
+const metaTenant = `// TenantColumn returns the column holding the object's tenant
+func (o *%[1]s) TenantColumn() string {
+	return "%[2]s"
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: full text search columns
+//	[3]: full text index queries
+const metaSearch = `
+
+// FTSColumns returns the columns indexed for full text search
+func (o *%[1]s) FTSColumns() []string {
+	return []string{%[2]s}
+}
+
+// SQLFTS returns the queries to create the full text index of the object's table
+func (o *%[1]s) SQLFTS() []string {
+	return []string{
+%[3]s}
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: changelog queries
+const metaSQLChanges = `
+
+// SQLChanges returns the queries to create the changelog of the object's table
+func (o *%[1]s) SQLChanges() []string {
+	return []string{
+%[2]s}
+}
+
+`
+
+// Arguments to format are:
+//	[1]: type name
+//	[2]: create index queries
+const metaSQLIndexes = `
+
+// SQLIndexes returns the queries to create the indexes for the object's table
+func (o *%[1]s) SQLIndexes() []string {
+	return []string{
+%[2]s}
 }
 
 `
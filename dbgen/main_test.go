@@ -3,7 +3,12 @@ package main
 import (
 	"database/sql"
 	"database/sql/driver"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
 	"os"
+	"strings"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -29,3 +34,565 @@ func TestInit(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// parseInfo returns the sql info for the struct types declared in src
+func parseInfo(t *testing.T, src string) []*SQLInfo {
+	t.Helper()
+	f := parseFile(t, src)
+	ast.Inspect(f.file, f.genDecl)
+	return f.values
+}
+
+func parseFile(t *testing.T, src string) *File {
+	t.Helper()
+	fs := token.NewFileSet()
+	parsed, err := parser.ParseFile(fs, "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &File{file: parsed}
+}
+
+// generated returns the code generated for the struct types declared in src
+func generated(t *testing.T, src string) (*Generator, string) {
+	t.Helper()
+	g := &Generator{pkg: &Package{files: []*File{parseFile(t, src)}}}
+	for _, info := range parseInfo(t, src) {
+		g.buildWrappers(info)
+	}
+	return g, g.buf.String()
+}
+
+const jsonSrc = `package objs
+
+type attrs struct {
+	Owner string
+}
+
+type tagged struct {
+	ID    int64             ` + "`sql:\"id,key\" table:\"tagged\"`" + `
+	Tags  []string          ` + "`sql:\"tags,json\"`" + `
+	Attrs attrs             ` + "`sql:\"attrs,json\"`" + `
+	Extra map[string]string ` + "`sql:\"extra,json\"`" + `
+}
+`
+
+func TestJSONTag(t *testing.T) {
+	infos := parseInfo(t, jsonSrc)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 type but got %d", len(infos))
+	}
+	info := infos[0]
+	for _, name := range []string{"Tags", "Attrs", "Extra"} {
+		if _, ok := info.JSON[name]; !ok {
+			t.Errorf("member %s is not marked as json", name)
+		}
+	}
+	for i, typ := range info.Types[1:] {
+		if typ != "text" {
+			t.Errorf("json column %d has type %q", i+1, typ)
+		}
+	}
+	g, code := generated(t, jsonSrc)
+	for _, want := range []string{
+		"rqlobj.JSON(o.Tags)",
+		"rqlobj.JSON(&o.Attrs)",
+		"extra text",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q", want)
+		}
+	}
+	if imports := g.importList(); len(imports) != 1 || imports[0] != rqlobjPkg {
+		t.Errorf("expected import of %s but got %v", rqlobjPkg, imports)
+	}
+}
+
+func TestJSONCheck(t *testing.T) {
+	*jsonCheck = true
+	defer func() { *jsonCheck = false }()
+	_, code := generated(t, jsonSrc)
+	const want = "tags text CHECK (json_valid(tags))"
+	if !strings.Contains(code, want) {
+		t.Errorf("generated code is missing %q:\n%s", want, code)
+	}
+}
+
+func TestColumnType(t *testing.T) {
+	tests := map[string]string{
+		"string":    "text",
+		"time.Time": "datetime",
+		"bool":      "integer",
+		"int8":      "integer",
+		"uint16":    "integer",
+		"uint64":    "integer",
+		"float32":   "real",
+		"float64":   "real",
+		"[]byte":    "blob",
+		"[]string":  "text",
+	}
+	for typ, want := range tests {
+		if got := columnType(typ); got != want {
+			t.Errorf("%s: got %s, want %s", typ, got, want)
+		}
+	}
+}
+
+const indexSrc = `package objs
+
+type host struct {
+	ID     int64  ` + "`sql:\"id,key\" table:\"hosts\"`" + `
+	Name   string ` + "`sql:\"name\" index:\"idx_name_kind,2\"`" + `
+	Kind   int    ` + "`sql:\"kind\" index:\"idx_name_kind,1;true\"`" + `
+	Serial string ` + "`sql:\"serial\" unique:\"true\"`" + `
+	Note   string ` + "`sql:\"note\" index:\"false\"`" + `
+	Tag    string ` + "`sql:\"tag\" index:\"true\" unique:\"true\"`" + `
+}
+`
+
+func TestIndexTags(t *testing.T) {
+	infos := parseInfo(t, indexSrc)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 type but got %d", len(infos))
+	}
+	want := []string{
+		"create index if not exists idx_name_kind on hosts (kind, name)",
+		"create index if not exists idx_hosts_kind on hosts (kind)",
+		"create unique index if not exists uidx_hosts_serial on hosts (serial)",
+		"create index if not exists idx_hosts_tag on hosts (tag)",
+		"create unique index if not exists uidx_hosts_tag on hosts (tag)",
+	}
+	indexes := infos[0].Indexes
+	if len(indexes) != len(want) {
+		t.Fatalf("expected %d indexes but got %d", len(want), len(indexes))
+	}
+	for i, index := range indexes {
+		if got := index.SQL(infos[0].Table); got != want[i] {
+			t.Errorf("got %q, want %q", got, want[i])
+		}
+	}
+	_, code := generated(t, indexSrc)
+	if !strings.Contains(code, "SQLIndexes() []string") {
+		t.Errorf("generated code is missing SQLIndexes:\n%s", code)
+	}
+	if _, code := generated(t, jsonSrc); strings.Contains(code, "SQLIndexes") {
+		t.Errorf("SQLIndexes generated for a type without indexes")
+	}
+}
+
+const constraintSrc = `package objs
+
+import "time"
+
+type item struct {
+	ID      int64     ` + "`sql:\"id,key\" table:\"items\"`" + `
+	Name    string    ` + "`sql:\"name,notnull\" collate:\"nocase\"`" + `
+	Kind    int       ` + "`sql:\"kind\" check:\"kind >= 0\" default:\"1\"`" + `
+	Serial  string    ` + "`sql:\"serial,unique\" notnull:\"true\"`" + `
+	Created time.Time ` + "`sql:\"created\" default:\"datetime('now')\"`" + `
+}
+`
+
+func TestColumnConstraints(t *testing.T) {
+	_, code := generated(t, constraintSrc)
+	for _, want := range []string{
+		"name text NOT NULL COLLATE nocase,",
+		"kind integer DEFAULT (1) CHECK (kind >= 0),",
+		"serial text NOT NULL UNIQUE,",
+		"created datetime DEFAULT (datetime('now'))",
+		`return []string{"kind","created"}`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+}
+
+const keySrc = `package objs
+
+type member struct {
+	GroupID int64  ` + "`sql:\"group_id,key\" table:\"members,withoutrowid\"`" + `
+	UserID  int64  ` + "`sql:\"user_id,key\"`" + `
+	Role    string ` + "`sql:\"role\"`" + `
+}
+
+type event struct {
+	ID   int64     ` + "`sql:\"id,key,autoincrement\" table:\"events,strict\"`" + `
+	When time.Time ` + "`sql:\"ts\"`" + `
+	Made time.Time ` + "`sql:\"made\" default:\"datetime('now')\"`" + `
+}
+
+type label struct {
+	Name string ` + "`sql:\"name,key\" table:\"labels\"`" + `
+}
+`
+
+func TestPrimaryKeys(t *testing.T) {
+	_, code := generated(t, keySrc)
+	for _, want := range []string{
+		"  group_id integer,\n  user_id integer,\n  role text,\n  PRIMARY KEY (group_id, user_id)\n) WITHOUT ROWID;",
+		"  id integer primary key autoincrement,\n  ts integer,\n  made integer DEFAULT (cast(strftime('%s', datetime('now')) as integer))\n) STRICT;",
+		// the types diffed against the table match its definition
+		"SQLTypes() []string {\n\treturn []string{\"integer\",\"integer\",\"integer\"}",
+		"  name text primary key\n);",
+		// keys are inserted, as they are not all rowids
+		"InsertFields() string {\n\treturn \"group_id,user_id,role\"",
+		"InsertValues() []interface{} {\n\treturn []interface{}{o.GroupID,o.UserID,o.Role}",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+}
+
+const invalidSrc = `package objs
+
+type keyless struct {
+	Name string ` + "`sql:\"name\" table:\"keyless,withoutrowid\"`" + `
+}
+
+type misspelt struct {
+	ID   int64  ` + "`sql:\"id,key\" table:\"misspelt,strikt\"`" + `
+	Name string ` + "`sql:\"name,uniq\"`" + `
+}
+`
+
+func TestInvalidOptions(t *testing.T) {
+	infos := parseInfo(t, invalidSrc)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 types but got %d", len(infos))
+	}
+	if len(infos[0].Invalid) != 1 || len(infos[1].Invalid) != 2 {
+		t.Errorf("invalid declarations not recorded: %q, %q", infos[0].Invalid, infos[1].Invalid)
+	}
+	g, _ := generated(t, invalidSrc)
+	if g.generate("keyless") || g.generate("") {
+		t.Error("invalid declarations were generated")
+	}
+	if g, _ := generated(t, keySrc); !g.generate("") {
+		t.Error("valid declarations were refused")
+	}
+}
+
+const relationSrc = `package objs
+
+type site struct {
+	ID    int64   ` + "`sql:\"id,key\" table:\"sites\"`" + `
+	Hosts []*host ` + "`rel:\"has_many,site_id\"`" + `
+}
+
+type host struct {
+	ID     int64 ` + "`sql:\"id,key\" table:\"hosts\"`" + `
+	SiteID int64 ` + "`sql:\"site_id\" fk:\"sites(id)\"`" + `
+	Site   site  ` + "`rel:\"belongs_to,site_id\"`" + `
+}
+`
+
+func TestRelations(t *testing.T) {
+	infos := parseInfo(t, relationSrc)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 types but got %d", len(infos))
+	}
+	hosts := infos[0].Relations[0]
+	if !hosts.HasMany || !hosts.Pointer || hosts.Type != "host" || hosts.Column != "site_id" {
+		t.Errorf("bad has_many relation: %+v", hosts)
+	}
+	site := infos[1].Relations[0]
+	if site.HasMany || site.Pointer || site.Type != "site" || site.Key != "id" {
+		t.Errorf("bad belongs_to relation: %+v", site)
+	}
+	_, code := generated(t, relationSrc)
+	for _, want := range []string{
+		"o := p.(*site); o.Hosts = append(o.Hosts, x.(*host))",
+		"p.(*host).Site = *x.(*site)",
+		"func (o *site) LoadHosts(db rqlobj.RDB) error",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+}
+
+const manySrc = `package objs
+
+type user struct {
+	ID     int64   ` + "`sql:\"id,key\" table:\"users\"`" + `
+	Groups []group ` + "`rel:\"many_to_many,user_groups,user_id,group_id\"`" + `
+}
+
+type group struct {
+	Code  string  ` + "`sql:\"code,key\" table:\"groups\"`" + `
+	Users []*user ` + "`rel:\"many_to_many,user_groups,group_id,user_id,ondelete=restrict\"`" + `
+}
+`
+
+func TestManyToMany(t *testing.T) {
+	infos := parseInfo(t, manySrc)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 types but got %d", len(infos))
+	}
+	groups := infos[0].Relations[0]
+	if !groups.HasMany || groups.Join == nil || groups.Join.Table != "user_groups" || groups.Join.OnDelete != "CASCADE" {
+		t.Errorf("bad many_to_many relation: %+v", groups)
+	}
+	users := infos[1].Relations[0]
+	if users.Join == nil || users.Join.Column != "group_id" || users.Join.OnDelete != "RESTRICT" {
+		t.Errorf("bad many_to_many relation: %+v", users)
+	}
+	_, code := generated(t, manySrc)
+	for _, want := range []string{
+		`user_id integer not null REFERENCES users(id) ON DELETE CASCADE`,
+		`group_id text not null REFERENCES groups(code) ON DELETE CASCADE`,
+		`group_id text not null REFERENCES groups(code) ON DELETE RESTRICT`,
+		`PRIMARY KEY (user_id, group_id)`,
+		`create index if not exists idx_user_groups_user_id on user_groups (user_id)`,
+		"o := p.(*user); o.Groups = append(o.Groups, *x.(*group))",
+		"func (o *group) LoadUsers(db rqlobj.RDB) error",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestForeignKey(t *testing.T) {
+	for _, tc := range []struct {
+		tag, want string
+	}{
+		{"sites(id)", " REFERENCES sites(id) ON UPDATE CASCADE"},
+		{"sites(id),ondelete=cascade", " REFERENCES sites(id) ON DELETE CASCADE ON UPDATE CASCADE"},
+		{"sites(id), ondelete=set  null, onupdate=restrict", " REFERENCES sites(id) ON DELETE SET NULL ON UPDATE RESTRICT"},
+		{"sites(id),onupdate=no action,deferrable", " REFERENCES sites(id) ON UPDATE NO ACTION DEFERRABLE INITIALLY DEFERRED"},
+		{"sites(id),deferrable=immediate", " REFERENCES sites(id) ON UPDATE CASCADE DEFERRABLE INITIALLY IMMEDIATE"},
+		{"sites(id),ondelete=explode", " REFERENCES sites(id) ON UPDATE CASCADE"},
+	} {
+		fk := parseForeignKey("Site", tc.tag)
+		if got := fk.SQL(); got != tc.want {
+			t.Errorf("%s: got %q\nwant %q", tc.tag, got, tc.want)
+		}
+		if fk.Column != "id" {
+			t.Errorf("%s: expected column id but got %q", tc.tag, fk.Column)
+		}
+	}
+	if fk := parseForeignKey("Site", ",ondelete=cascade"); fk != nil {
+		t.Errorf("expected no foreign key without a reference but got %+v", fk)
+	}
+}
+
+func TestColumnReceivers(t *testing.T) {
+	_, code := generated(t, jsonSrc)
+	const want = `func (o *tagged) ColumnReceivers() map[string]interface{} {
+	return map[string]interface{}{
+		"id":    &o.ID,
+		"tags":  rqlobj.JSON(&o.Tags),
+		"attrs": rqlobj.JSON(&o.Attrs),
+		"extra": rqlobj.JSON(&o.Extra),
+	}
+}`
+	if formatted, err := format.Source([]byte(code)); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(formatted), want) {
+		t.Errorf("generated code is missing %q:\n%s", want, formatted)
+	}
+}
+
+const trackedSrc = `package objs
+
+type tracked struct {
+	rqlobj.Tracked
+	ID   int64  ` + "`sql:\"id,key\" table:\"tracked\"`" + `
+	Name string ` + "`sql:\"name\"`" + `
+}
+`
+
+func TestTracked(t *testing.T) {
+	infos := parseInfo(t, trackedSrc)
+	if len(infos) != 1 || !infos[0].Tracked {
+		t.Fatalf("expected a tracked type: %+v", infos)
+	}
+	if len(infos[0].Order) != 1 {
+		t.Errorf("the embedded tracker is not a column: %v", infos[0].Order)
+	}
+	_, code := generated(t, trackedSrc)
+	for _, want := range []string{
+		"func (o *tracked) Snapshot() {\n\to.Tracked.Record(o)",
+		"func (o *tracked) Changed() []string {\n\treturn o.Tracked.Compare(o)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+}
+
+const ftsSrc = `package objs
+
+type doc struct {
+	ID    int64  ` + "`sql:\"id,key\" table:\"docs\"`" + `
+	Title string ` + "`sql:\"title\" fts:\"true\"`" + `
+	Body  string ` + "`sql:\"body\" fts:\"true\"`" + `
+	Kind  int    ` + "`sql:\"kind\" fts:\"true\"`" + `
+}
+`
+
+func TestFTS(t *testing.T) {
+	infos := parseInfo(t, ftsSrc)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 type but got %d", len(infos))
+	}
+	info := infos[0]
+	// only strings are indexed
+	if len(info.FTS) != 2 || info.FTS[0] != "title" || info.FTS[1] != "body" {
+		t.Fatalf("bad fts columns: %v", info.FTS)
+	}
+	_, code := generated(t, ftsSrc)
+	for _, want := range []string{
+		"func (o *doc) FTSColumns() []string",
+		"func (o *doc) SQLFTS() []string",
+		"using fts5(title, body, content='docs', content_rowid='id')",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+
+	// the index follows the table, if sqlite is built with fts5
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table docs (id integer primary key, title text, body text, kind integer)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range ftsSQL(info) {
+		if _, err := db.Exec(query); err != nil {
+			if strings.Contains(err.Error(), "no such module") {
+				t.Skip("sqlite lacks fts5, build with -tags sqlite_fts5")
+			}
+			t.Fatalf("%v: %s", err, query)
+		}
+	}
+	for _, query := range []string{
+		"insert into docs (id, title, body) values (1, 'rack one', 'holds the web servers')",
+		"insert into docs (id, title, body) values (2, 'rack two', 'holds the database servers')",
+		"insert into docs (id, title, body) values (3, 'spares', 'old web servers')",
+		"update docs set body = 'holds the cache servers' where id = 1",
+		"delete from docs where id = 3",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%v: %s", err, query)
+		}
+	}
+	for match, want := range map[string]int{"servers": 2, "web": 0, "cache": 1, "rack": 2, "spares": 0} {
+		var n int
+		if err := db.QueryRow("select count(*) from docs_fts where docs_fts match ?", match).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("%s: expected %d matches but got %d", match, want, n)
+		}
+	}
+}
+
+const changesSrc = `package objs
+
+type host struct {
+	ID   int64  ` + "`sql:\"id,key\" table:\"hosts,changes\"`" + `
+	Name string ` + "`sql:\"name\"`" + `
+	Data []byte ` + "`sql:\"data\"`" + `
+}
+`
+
+func TestChanges(t *testing.T) {
+	infos := parseInfo(t, changesSrc)
+	info := infos[0]
+	if !info.Changes || len(info.Options) > 0 {
+		t.Fatalf("expected changes without table options: %v %v", info.Changes, info.Options)
+	}
+	_, code := generated(t, changesSrc)
+	for _, want := range []string{
+		"func (o *host) SQLChanges() []string",
+		"json_object('id', new.id, 'name', new.name, 'data', hex(new.data))",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code is missing %q:\n%s", want, code)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	queries := append([]string{"create table hosts (id integer primary key, name text, data blob)"},
+		changesSQL(info, []string{"id", "name", "data"})...)
+	queries = append(queries,
+		"insert into hosts (id, name, data) values (1, 'one', X'0102')",
+		"update hosts set name = 'uno' where id = 1",
+		"delete from hosts where id = 1",
+	)
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			if strings.Contains(err.Error(), "no such function: json") {
+				t.Skip("sqlite lacks json1, build with -tags sqlite_json")
+			}
+			t.Fatalf("%v: %s", err, query)
+		}
+	}
+	rows, err := db.Query("select id, op, keys, object from hosts_changes order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := [][]string{
+		{"insert", "[1]", `{"id":1,"name":"one","data":"0102"}`},
+		{"update", "[1]", `{"id":1,"name":"uno","data":"0102"}`},
+		{"delete", "[1]", ""},
+	}
+	var n int
+	for ; rows.Next(); n++ {
+		var id int64
+		var op, keys string
+		var object sql.NullString
+		if err := rows.Scan(&id, &op, &keys, &object); err != nil {
+			t.Fatal(err)
+		}
+		if n >= len(want) {
+			break
+		}
+		if id != int64(n+1) || op != want[n][0] || keys != want[n][1] || object.String != want[n][2] {
+			t.Errorf("change %d: got %s %s %s", id, op, keys, object.String)
+		}
+	}
+	if n != len(want) {
+		t.Errorf("expected %d changes but got %d", len(want), n)
+	}
+}
+
+const tenantSrc = `package objs
+
+type host struct {
+	ID       int64  ` + "`sql:\"id,key\" table:\"hosts\"`" + `
+	TenantID int64  ` + "`sql:\"tenant_id\" tenant:\"true\" index:\"true\"`" + `
+	Name     string ` + "`sql:\"name\"`" + `
+	OwnerID  int64  ` + "`sql:\"owner_id\" tenant:\"true\"`" + `
+}
+`
+
+func TestTenant(t *testing.T) {
+	infos := parseInfo(t, tenantSrc)
+	// only the first member tagged holds the tenant
+	if infos[0].Tenant != "tenant_id" {
+		t.Fatalf("bad tenant column: %q", infos[0].Tenant)
+	}
+	_, code := generated(t, tenantSrc)
+	const want = "func (o *host) TenantColumn() string {\n\treturn \"tenant_id\"\n}"
+	if !strings.Contains(code, want) {
+		t.Errorf("generated code is missing %q:\n%s", want, code)
+	}
+	// deletes log the tenant of the row
+	const logged = "values ('delete', json_array(old.id), json_object('tenant_id', old.tenant_id));"
+	if queries := changesSQL(infos[0], []string{"id", "tenant_id"}); !strings.Contains(queries[3], logged) {
+		t.Errorf("delete trigger does not log the tenant: %s", queries[3])
+	}
+}
@@ -0,0 +1,538 @@
+package rqlobj
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ColumnTyper is implemented by objects that report the declared type
+// of each of their SelectFields, in the same order, as generated by dbgen
+type ColumnTyper interface {
+	SQLTypes() []string
+}
+
+// Indexer is implemented by objects that declare indexes on their table
+type Indexer interface {
+	// SQLIndexes returns the statements to create the indexes
+	SQLIndexes() []string
+}
+
+// Column is a table column and its declared type
+type Column struct {
+	Name string
+	Type string
+}
+
+// ColumnChange is a column declared with a type other than expected
+type ColumnChange struct {
+	Name     string
+	Expected string
+	Actual   string
+}
+
+// TableDiff describes how the live table differs from its object
+type TableDiff struct {
+	Table          string
+	Missing        bool // the table does not exist
+	MissingColumns []Column
+	ExtraColumns   []Column
+	Mistyped       []ColumnChange
+	MissingIndexes []string
+	ExtraIndexes   []string
+	obj            DBObject
+	prefix         string // prefix of table names, if namespaced
+}
+
+// Changed returns true if the table does not match its object
+func (d TableDiff) Changed() bool {
+	return d.Missing ||
+		len(d.MissingColumns) > 0 ||
+		len(d.ExtraColumns) > 0 ||
+		len(d.Mistyped) > 0 ||
+		len(d.MissingIndexes) > 0 ||
+		len(d.ExtraIndexes) > 0
+}
+
+// Diff compares the tables of the objects against the live database,
+// returning the differences of the tables that do not match
+func (db RDB) Diff(objs ...DBObject) ([]TableDiff, error) {
+	var diffs []TableDiff
+	for _, o := range objs {
+		columns := &tableColumns{table: db.Table(o)}
+		if err := db.List(columns); err != nil {
+			return nil, err
+		}
+		indexes := &tableIndexes{table: db.Table(o)}
+		if err := db.List(indexes); err != nil {
+			return nil, err
+		}
+		if diff := compareTable(db.prefix, o, columns.columns, indexes.names); diff.Changed() {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+const (
+	// convergeCheck is the temporary table that rolls back the rebuild of
+	// tables unless it brought them in line with their objects, keeping
+	// their rows, and all rows still find those their foreign keys reference
+	convergeCheck = "rqlobj_converge_check"
+
+	// convergeRows is the temporary table of the row counts of the
+	// rebuilt tables, before and after their rebuild
+	convergeRows = "rqlobj_converge_rows"
+)
+
+// Converge applies the statements needed for the tables to match their objects.
+// Tables are rebuilt with foreign keys unenforced, as otherwise dropping them
+// would delete or update the rows referencing them, and the rebuild is undone
+// if any of those rows no longer find the row they reference
+func (db RDB) Converge(objs ...DBObject) error {
+	diffs, err := db.Diff(objs...)
+	if err != nil {
+		return err
+	}
+	var queries []string
+	var rebuilt bool
+	for _, diff := range diffs {
+		queries = append(queries, diff.Statements()...)
+		rebuilt = rebuilt || !diff.Missing && diff.rebuild()
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	if rebuilt {
+		on, err := db.ForeignKeys()
+		if err != nil {
+			return err
+		}
+		if on {
+			return db.withoutForeignKeys(diffs, queries)
+		}
+	}
+	results, err := db.Write(queries...)
+	return writeError(err, results, queries)
+}
+
+// withoutForeignKeys writes the queries of the diffs with foreign keys unenforced.
+// The pragma is ignored within a transaction, so the request is not written as
+// one but begins and commits its own, with the pragmas around it. Its statements
+// carry on past errors, so ahead of the commit the transaction is rolled back
+// unless the tables match their objects and keep their rows, and all rows
+// find those their foreign keys reference, as per pragma foreign_key_check
+func (db RDB) withoutForeignKeys(diffs []TableDiff, queries []string) error {
+	statements := []string{
+		"pragma foreign_keys=off",
+		"begin",
+		"create temp table " + convergeRows + " (name text, stage text, n integer)",
+		"create temp table " + convergeCheck + " (violations integer check (violations = 0))",
+	}
+	const count = "insert into %s select %s, '%s', count(*) from %s"
+	var before, after []string
+	violations := []string{"(select count(*) from pragma_foreign_key_check)"}
+	for _, diff := range diffs {
+		if !diff.Missing && diff.rebuild() {
+			before = append(before, fmt.Sprintf(count, convergeRows, formatted(diff.Table), "before", diff.Table))
+			after = append(after, fmt.Sprintf(count, convergeRows, formatted(diff.Table), "after", diff.Table))
+		}
+		violations = append(violations, diff.unconverged())
+	}
+	// the tables may be missing after errors, so their rows are counted
+	// on their own, while the check only reads what is sure to exist
+	const kept = "(select count(*) from %s b where stage = 'before' and not exists " +
+		"(select 1 from %s a where stage = 'after' and a.name = b.name and a.n = b.n))"
+	violations = append(violations, fmt.Sprintf(kept, convergeRows, convergeRows))
+	statements = append(statements, before...)
+	statements = append(statements, queries...)
+	statements = append(statements, after...)
+	statements = append(statements,
+		"insert or rollback into "+convergeCheck+" select "+strings.Join(violations, " + "),
+		"drop table "+convergeCheck,
+		"drop table "+convergeRows,
+		"commit",
+		"pragma foreign_keys=on",
+	)
+	start := time.Now()
+	results, err := db.statements("/db/execute", statements, false)
+	db.logSlow(start, statements...)
+	return writeError(err, writeResults(results), statements)
+}
+
+// unconverged returns an expression counting how the live table still differs
+// from its object, after the statements to converge it: columns missing,
+// left over or still mistyped, and indexes missing
+func (d TableDiff) unconverged() string {
+	var columns, extra []interface{}
+	for _, c := range expectedColumns(d.obj) {
+		columns = append(columns, strings.ToLower(c.Name))
+	}
+	for _, c := range d.ExtraColumns {
+		extra = append(extra, strings.ToLower(c.Name))
+	}
+	var mistyped []string
+	for _, c := range d.Mistyped {
+		mistyped = append(mistyped, fmt.Sprintf("lower(name) = %s and type = %s", formatted(strings.ToLower(c.Name)), formatted(c.Actual)))
+	}
+	found := fmt.Sprintf("total(lower(name) in (%s)) != %d", fieldList(columns...), len(columns))
+	if len(extra) > 0 {
+		found += fmt.Sprintf(" or total(lower(name) in (%s)) > 0", fieldList(extra...))
+	}
+	if len(mistyped) > 0 {
+		found += fmt.Sprintf(" or total(%s) > 0", strings.Join(mistyped, " or "))
+	}
+	expr := fmt.Sprintf("(select %s from pragma_table_info(%s))", found, formatted(d.Table))
+	names, _ := expectedIndexes(d.prefix, d.obj)
+	if len(names) > 0 {
+		indexes := make([]interface{}, len(names))
+		for i, name := range names {
+			indexes[i] = strings.ToLower(name)
+		}
+		const text = " + (select count(*) != %d from sqlite_master where type = 'index' and lower(name) in (%s))"
+		expr += fmt.Sprintf(text, len(names), fieldList(indexes...))
+	}
+	return expr
+}
+
+// expectedColumns returns the columns of the object's table
+func expectedColumns(o DBObject) []Column {
+	fields := strings.Split(o.SelectFields(), ",")
+	var types []string
+	if typer, ok := o.(ColumnTyper); ok {
+		types = typer.SQLTypes()
+	}
+	columns := make([]Column, len(fields))
+	for i, field := range fields {
+		columns[i].Name = strings.TrimSpace(field)
+		if i < len(types) {
+			columns[i].Type = types[i]
+		}
+	}
+	return columns
+}
+
+// indexName returns the name of the index created by the statement
+var indexName = regexp.MustCompile(`(?i)create\s+(?:unique\s+)?index\s+(?:if\s+not\s+exists\s+)?["\x60]?(\w+)`)
+
+// expectedIndexes returns the index names and their create statements,
+// in the namespace of the prefix
+func expectedIndexes(prefix string, o DBObject) ([]string, map[string]string) {
+	indexer, ok := o.(Indexer)
+	if !ok {
+		return nil, nil
+	}
+	var names []string
+	create := make(map[string]string)
+	for _, query := range namespaceAll(prefix, indexer.SQLIndexes()) {
+		if match := indexName.FindStringSubmatch(query); match != nil {
+			names = append(names, match[1])
+			create[match[1]] = query
+		}
+	}
+	return names, create
+}
+
+// compareTable returns the differences between the object and its live table,
+// in the namespace of the prefix
+func compareTable(prefix string, o DBObject, live []Column, indexes []string) TableDiff {
+	diff := TableDiff{Table: prefix + o.TableName(), obj: o, prefix: prefix}
+	if len(live) == 0 {
+		diff.Missing = true
+		return diff
+	}
+	have := make(map[string]Column, len(live))
+	for _, c := range live {
+		have[strings.ToLower(c.Name)] = c
+	}
+	want := make(map[string]struct{})
+	for _, c := range expectedColumns(o) {
+		want[strings.ToLower(c.Name)] = struct{}{}
+		actual, ok := have[strings.ToLower(c.Name)]
+		switch {
+		case !ok:
+			diff.MissingColumns = append(diff.MissingColumns, c)
+		case c.Type != "" && affinity(c.Type) != affinity(actual.Type):
+			diff.Mistyped = append(diff.Mistyped, ColumnChange{
+				Name:     c.Name,
+				Expected: c.Type,
+				Actual:   actual.Type,
+			})
+		}
+	}
+	for _, c := range live {
+		if _, ok := want[strings.ToLower(c.Name)]; !ok {
+			diff.ExtraColumns = append(diff.ExtraColumns, c)
+		}
+	}
+	names, _ := expectedIndexes(prefix, o)
+	existing := make(map[string]struct{}, len(indexes))
+	for _, name := range indexes {
+		existing[strings.ToLower(name)] = struct{}{}
+	}
+	declared := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		declared[strings.ToLower(name)] = struct{}{}
+		if _, ok := existing[strings.ToLower(name)]; !ok {
+			diff.MissingIndexes = append(diff.MissingIndexes, name)
+		}
+	}
+	if _, ok := o.(Indexer); ok {
+		// without declared indexes, any found are presumed to be managed elsewhere
+		for _, name := range indexes {
+			if _, ok := declared[strings.ToLower(name)]; !ok {
+				diff.ExtraIndexes = append(diff.ExtraIndexes, name)
+			}
+		}
+	}
+	return diff
+}
+
+// Statements returns the statements that bring the table in line with its object.
+// Missing tables are created as by CreateTables, with their indexes, full text
+// index and changelog. Missing columns are added in place, as defined by the object's create statement,
+// while extra or mistyped columns, and missing columns that sqlite cannot add,
+// require the table to be rebuilt and its data copied over. Rebuilds drop
+// the table, so foreign keys must not be enforced when they run, as Converge
+// sees to
+func (d TableDiff) Statements() []string {
+	if d.obj == nil || !d.Changed() {
+		return nil
+	}
+	_, indexes := expectedIndexes(d.prefix, d.obj)
+	if d.Missing {
+		queries := []string{d.obj.SQLCreate()}
+		if indexer, ok := d.obj.(Indexer); ok {
+			queries = append(queries, indexer.SQLIndexes()...)
+		}
+		if searcher, ok := d.obj.(Searcher); ok {
+			queries = append(queries, searcher.SQLFTS()...)
+		}
+		if logger, ok := d.obj.(ChangeLogger); ok {
+			queries = append(queries, logger.SQLChanges()...)
+		}
+		return namespaceAll(d.prefix, queries)
+	}
+	if d.rebuild() {
+		return d.rebuildTable()
+	}
+	defs := columnDefs(d.obj.SQLCreate())
+	var queries []string
+	for _, c := range d.MissingColumns {
+		def, _ := addableColumn(c, defs)
+		queries = append(queries, fmt.Sprintf("alter table %s add column %s", d.Table, namespaceSQL(d.prefix, def)))
+	}
+	for _, name := range d.ExtraIndexes {
+		queries = append(queries, "drop index if exists "+name)
+	}
+	for _, name := range d.MissingIndexes {
+		queries = append(queries, indexes[name])
+	}
+	return queries
+}
+
+// rebuild returns true if the table cannot be altered in place
+func (d TableDiff) rebuild() bool {
+	if len(d.ExtraColumns) > 0 || len(d.Mistyped) > 0 {
+		return true
+	}
+	// sqlite cannot add key columns, nor some constraints
+	defs := columnDefs(d.obj.SQLCreate())
+	for _, c := range d.MissingColumns {
+		if within(c.Name, d.obj.KeyFields()) {
+			return true
+		}
+		if _, ok := addableColumn(c, defs); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// columnDefs returns the definitions of the columns of a create table
+// statement, with their constraints, by lowercased column name
+func columnDefs(create string) map[string]string {
+	start := strings.IndexByte(create, '(')
+	end := strings.LastIndexByte(create, ')')
+	if start < 0 || end < start {
+		return nil
+	}
+	defs := make(map[string]string)
+	for _, def := range splitDefs(create[start+1 : end]) {
+		if fields := strings.Fields(def); len(fields) > 0 {
+			name := strings.Trim(fields[0], "\"`[]")
+			defs[strings.ToLower(name)] = def
+		}
+	}
+	return defs
+}
+
+// splitDefs splits the definitions of a create table statement on
+// the commas between them, rather than those within parentheses or quotes
+func splitDefs(text string) []string {
+	var defs []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(defs, strings.TrimSpace(text[start:]))
+}
+
+var (
+	// literalDefault matches a default of a literal in parentheses,
+	// which alter table takes without them
+	literalDefault = regexp.MustCompile(`(?i)\bdefault\s*\(\s*(-?[0-9]+(?:\.[0-9]+)?|'(?:[^']|'')*'|null)\s*\)`)
+
+	// unaddable matches the constraints that alter table cannot add:
+	// keys, unique columns, and defaults of expressions or the current time
+	unaddable = regexp.MustCompile(`(?i)\b(primary\s+key|unique)\b|\bdefault\s*\(|\bcurrent_(time|date|timestamp)\b`)
+
+	// defaultValue matches the default of a column
+	defaultValue = regexp.MustCompile(`(?i)\bdefault\s+(\S+)`)
+)
+
+// hasDefault returns true if the column definition has a default other than null
+func hasDefault(def string) bool {
+	match := defaultValue.FindStringSubmatch(def)
+	return match != nil && !strings.EqualFold(match[1], "null")
+}
+
+// addableColumn returns the definition to add the missing column with,
+// and false if sqlite cannot add it in place. Columns that are not null
+// need a default, and those with references cannot have one while foreign
+// keys are enforced
+func addableColumn(c Column, defs map[string]string) (string, bool) {
+	def, ok := defs[strings.ToLower(c.Name)]
+	if !ok {
+		return strings.TrimSpace(c.Name + " " + c.Type), true
+	}
+	def = literalDefault.ReplaceAllString(def, "DEFAULT $1")
+	if unaddable.MatchString(def) {
+		return def, false
+	}
+	lower := strings.ToLower(def)
+	if strings.Contains(lower, "not null") && !hasDefault(def) ||
+		strings.Contains(lower, "references") && hasDefault(def) {
+		return def, false
+	}
+	return def, true
+}
+
+// createTable matches the table name of a create table statement
+var createTable = regexp.MustCompile(`(?i)^(\s*create\s+table\s+(?:if\s+not\s+exists\s+)?)["\x60]?\w+["\x60]?`)
+
+// rebuildTable returns the statements to recreate the table with its data.
+// The triggers of its full text index and changelog are dropped along with
+// the table, so are recreated, and the full text index is rebuilt from the
+// new table, while the changelog keeps its changes
+func (d TableDiff) rebuildTable() []string {
+	temp := d.Table + "__new"
+	missing := make(map[string]struct{}, len(d.MissingColumns))
+	for _, c := range d.MissingColumns {
+		missing[c.Name] = struct{}{}
+	}
+	var common []string
+	for _, c := range expectedColumns(d.obj) {
+		if _, ok := missing[c.Name]; !ok {
+			common = append(common, c.Name)
+		}
+	}
+	fields := join(common)
+	queries := []string{
+		"drop table if exists " + temp,
+		createTable.ReplaceAllString(namespaceSQL(d.prefix, d.obj.SQLCreate()), "${1}"+temp),
+		fmt.Sprintf("insert into %s (%s) select %s from %s", temp, fields, fields, d.Table),
+		"drop table " + d.Table,
+		fmt.Sprintf("alter table %s rename to %s", temp, d.Table),
+	}
+	if indexer, ok := d.obj.(Indexer); ok {
+		queries = append(queries, namespaceAll(d.prefix, indexer.SQLIndexes())...)
+	}
+	if searcher, ok := d.obj.(Searcher); ok {
+		fts := d.Table + ftsSuffix
+		queries = append(queries, "drop table if exists "+fts)
+		queries = append(queries, namespaceAll(d.prefix, searcher.SQLFTS())...)
+		queries = append(queries, fmt.Sprintf("insert into %s(%s) values ('rebuild')", fts, fts))
+	}
+	if logger, ok := d.obj.(ChangeLogger); ok {
+		queries = append(queries, namespaceAll(d.prefix, logger.SQLChanges())...)
+	}
+	return queries
+}
+
+// affinity returns the sqlite type affinity for the declared column type
+// as per https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func affinity(declared string) string {
+	t := strings.ToUpper(declared)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "integer"
+	case strings.Contains(t, "CHAR"),
+		strings.Contains(t, "CLOB"),
+		strings.Contains(t, "TEXT"):
+		return "text"
+	case strings.Contains(t, "BLOB"), t == "":
+		return "blob"
+	case strings.Contains(t, "REAL"),
+		strings.Contains(t, "FLOA"),
+		strings.Contains(t, "DOUB"):
+		return "real"
+	}
+	return "numeric"
+}
+
+// tableColumns is a DBList of the columns of a live table
+type tableColumns struct {
+	table   string
+	columns []Column
+}
+
+func (t *tableColumns) SQLGet(extra string) string {
+	return fmt.Sprintf("select name, type from pragma_table_info(%s) %s", formatted(t.table), extra)
+}
+
+func (t *tableColumns) SQLResults(fn func(...interface{}) error) error {
+	var c Column
+	if err := fn(&c.Name, &c.Type); err != nil {
+		return err
+	}
+	t.columns = append(t.columns, c)
+	return nil
+}
+
+// tableIndexes is a DBList of the explicitly created indexes of a live table
+type tableIndexes struct {
+	table string
+	names []string
+}
+
+func (t *tableIndexes) SQLGet(extra string) string {
+	const text = "select name from pragma_index_list(%s) where origin = 'c' %s"
+	return fmt.Sprintf(text, formatted(t.table), extra)
+}
+
+func (t *tableIndexes) SQLResults(fn func(...interface{}) error) error {
+	var name string
+	if err := fn(&name); err != nil {
+		return err
+	}
+	t.names = append(t.names, name)
+	return nil
+}
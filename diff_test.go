@@ -0,0 +1,347 @@
+package rqlobj
+
+import (
+	"strings"
+	"testing"
+)
+
+// typedTable adds the column types and indexes generated by dbgen
+type typedTable struct {
+	*tableObject
+	types   []string
+	indexes []string
+}
+
+func (o *typedTable) SQLTypes() []string {
+	return o.types
+}
+
+func (o *typedTable) SQLIndexes() []string {
+	return o.indexes
+}
+
+func newTypedTable() *typedTable {
+	// testStruct selects id,name,kind,data,modified
+	return &typedTable{
+		tableObject: newTable(tableName, "  id integer primary key,\n  name text,\n  kind integer,\n  data text,\n  modified datetime"),
+		types:       []string{"integer", "text", "integer", "text", "datetime"},
+		indexes:     []string{"create index if not exists idx_kind on " + tableName + " (kind)"},
+	}
+}
+
+func TestCompareMissingTable(t *testing.T) {
+	o := newTypedTable()
+	diff := compareTable("", o, nil, nil)
+	if !diff.Missing {
+		t.Fatal("expected missing table")
+	}
+	queries := diff.Statements()
+	if len(queries) != 2 || queries[0] != o.SQLCreate() || queries[1] != o.indexes[0] {
+		t.Errorf("unexpected statements: %q", queries)
+	}
+}
+
+func TestCompareAddColumn(t *testing.T) {
+	o := newTypedTable()
+	live := []Column{
+		{"id", "INTEGER"},
+		{"name", "TEXT"},
+		{"kind", "INT"},
+		{"data", "varchar(20)"},
+	}
+	diff := compareTable("", o, live, []string{"idx_old"})
+	if len(diff.MissingColumns) != 1 || diff.MissingColumns[0].Name != "modified" {
+		t.Errorf("missing columns: %+v", diff.MissingColumns)
+	}
+	if len(diff.Mistyped) != 0 {
+		t.Errorf("types of the same affinity should match: %+v", diff.Mistyped)
+	}
+	if len(diff.MissingIndexes) != 1 || len(diff.ExtraIndexes) != 1 {
+		t.Errorf("indexes: missing %v extra %v", diff.MissingIndexes, diff.ExtraIndexes)
+	}
+	want := []string{
+		"alter table test_structs add column modified datetime",
+		"drop index if exists idx_old",
+		o.indexes[0],
+	}
+	if got := diff.Statements(); strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("got statements:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestCompareRebuild(t *testing.T) {
+	o := newTypedTable()
+	live := []Column{
+		{"id", "integer"},
+		{"name", "text"},
+		{"kind", "text"},
+		{"data", "text"},
+		{"modified", "datetime"},
+		{"legacy", "text"},
+	}
+	diff := compareTable("", o, live, []string{"idx_kind"})
+	if len(diff.Mistyped) != 1 || diff.Mistyped[0].Name != "kind" {
+		t.Errorf("mistyped: %+v", diff.Mistyped)
+	}
+	if len(diff.ExtraColumns) != 1 || diff.ExtraColumns[0].Name != "legacy" {
+		t.Errorf("extra: %+v", diff.ExtraColumns)
+	}
+	queries := diff.Statements()
+	if len(queries) != 6 {
+		t.Fatalf("unexpected statements: %q", queries)
+	}
+	if !strings.HasPrefix(queries[1], "create table if not exists test_structs__new (") {
+		t.Errorf("bad create: %s", queries[1])
+	}
+	const copyRows = "insert into test_structs__new (id,name,kind,data,modified) select id,name,kind,data,modified from test_structs"
+	if queries[2] != copyRows {
+		t.Errorf("bad copy: %s", queries[2])
+	}
+}
+
+func TestAddableColumn(t *testing.T) {
+	defs := columnDefs(`create table if not exists hosts (
+  id integer primary key,
+  kind integer NOT NULL DEFAULT (0) CHECK (kind in (0, 1)),
+  site_id integer REFERENCES sites(id) ON DELETE CASCADE,
+  owner_id integer DEFAULT (1) REFERENCES users(id),
+  name text NOT NULL,
+  code text UNIQUE,
+  note text DEFAULT ('it''s, new'),
+  made datetime DEFAULT (datetime('now')),
+  seen datetime DEFAULT CURRENT_TIMESTAMP
+);`)
+	tests := []struct {
+		name string
+		def  string
+		ok   bool
+	}{
+		{"kind", "kind integer NOT NULL DEFAULT 0 CHECK (kind in (0, 1))", true},
+		{"site_id", "site_id integer REFERENCES sites(id) ON DELETE CASCADE", true},
+		{"note", "note text DEFAULT 'it''s, new'", true},
+		{"extra", "extra text", true},
+		{"id", "id integer primary key", false},
+		{"owner_id", "owner_id integer DEFAULT 1 REFERENCES users(id)", false},
+		{"name", "name text NOT NULL", false},
+		{"code", "code text UNIQUE", false},
+		{"made", "made datetime DEFAULT (datetime('now'))", false},
+		{"seen", "seen datetime DEFAULT CURRENT_TIMESTAMP", false},
+	}
+	for _, test := range tests {
+		def, ok := addableColumn(Column{Name: test.name, Type: "text"}, defs)
+		if def != test.def || ok != test.ok {
+			t.Errorf("%s: got %q (%v), want %q (%v)", test.name, def, ok, test.def, test.ok)
+		}
+	}
+}
+
+func TestCompareAddConstrained(t *testing.T) {
+	o := newTypedTable()
+	o.create = strings.Replace(o.create, "kind integer", "kind integer NOT NULL DEFAULT (0) CHECK (kind >= 0)", 1)
+	live := []Column{
+		{"id", "integer"},
+		{"name", "text"},
+		{"data", "text"},
+		{"modified", "datetime"},
+	}
+	diff := compareTable("", o, live, []string{"idx_kind"})
+	want := "alter table test_structs add column kind integer NOT NULL DEFAULT 0 CHECK (kind >= 0)"
+	if got := diff.Statements(); len(got) != 1 || got[0] != want {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+
+	// a default of an expression cannot be added in place
+	o.create = strings.Replace(o.create, "modified datetime", "modified datetime DEFAULT (datetime('now'))", 1)
+	live = live[:3]
+	diff = compareTable("", o, live, []string{"idx_kind"})
+	if !diff.rebuild() {
+		t.Errorf("expected a rebuild for %+v", diff.MissingColumns)
+	}
+}
+
+func TestConvergeForeignKeys(t *testing.T) {
+	db, local := sqliteServer(t)
+	for _, query := range []string{
+		"pragma foreign_keys=on",
+		"create table test_structs (id integer primary key, name text, kind integer, data text, modified datetime, legacy text)",
+		"create table children (id integer primary key, parent_id integer REFERENCES test_structs(id) ON DELETE CASCADE)",
+		"insert into test_structs (id, name) values (1, 'one'), (2, 'two')",
+		"insert into children (parent_id) values (1), (2), (2)",
+	} {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Converge(newTypedTable()); err != nil {
+		t.Fatal(err)
+	}
+	var children, legacy, on int
+	local.QueryRow("select count(*) from children").Scan(&children)
+	local.QueryRow("select count(*) from pragma_table_info('test_structs') where name = 'legacy'").Scan(&legacy)
+	local.QueryRow("pragma foreign_keys").Scan(&on)
+	if children != 3 || legacy != 0 || on != 1 {
+		t.Errorf("after the rebuild: %d children, %d legacy columns, foreign keys %d", children, legacy, on)
+	}
+
+	// the rebuild is undone when rows do not find those they reference
+	for _, query := range []string{
+		"alter table test_structs add column legacy text",
+		"pragma foreign_keys=off",
+		"insert into children (parent_id) values (9)",
+		"pragma foreign_keys=on",
+	} {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := newTypedTable()
+	if err := db.Converge(o); err == nil || !strings.Contains(err.Error(), convergeCheck) {
+		t.Errorf("expected the foreign key check to fail: %v", err)
+	}
+	local.QueryRow("select count(*) from children").Scan(&children)
+	local.QueryRow("pragma foreign_keys").Scan(&on)
+	local.QueryRow("select count(*) from pragma_table_info('test_structs') where name = 'legacy'").Scan(&legacy)
+	if children != 4 || legacy != 1 || on != 1 {
+		t.Errorf("after the failed rebuild: %d children, %d legacy columns, foreign keys %d", children, legacy, on)
+	}
+
+	// as are the statements following one that fails, which carry on,
+	// even without rows referencing the table to fail the foreign key check
+	for _, query := range []string{
+		"delete from children",
+		"insert into test_structs (id) values (3)",
+	} {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o = newTypedTable()
+	o.tableObject = newTable(tableName, "  id integer primary key,\n  name text not null,\n  kind integer,\n  data text,\n  modified datetime")
+	if err := db.Converge(o); err == nil || !strings.Contains(err.Error(), "NOT NULL") {
+		t.Errorf("expected the copy of the rows to fail: %v", err)
+	}
+	var rows int
+	local.QueryRow("select count(*) from test_structs").Scan(&rows)
+	local.QueryRow("select count(*) from children").Scan(&children)
+	local.QueryRow("pragma foreign_keys").Scan(&on)
+	local.QueryRow("select count(*) from pragma_table_info('test_structs') where name = 'legacy'").Scan(&legacy)
+	if rows != 3 || children != 0 || legacy != 1 || on != 1 {
+		t.Errorf("after the failed copy: %d rows, %d children, %d legacy columns, foreign keys %d", rows, children, legacy, on)
+	}
+}
+
+// loggedTable adds the changelog generated by dbgen
+type loggedTable struct {
+	*typedTable
+	changes []string
+}
+
+func (o *loggedTable) SQLChanges() []string {
+	return o.changes
+}
+
+// indexedTable adds the full text index generated by dbgen
+type indexedTable struct {
+	*loggedTable
+	fts []string
+}
+
+func (o *indexedTable) FTSColumns() []string {
+	return []string{"name"}
+}
+
+func (o *indexedTable) SQLFTS() []string {
+	return o.fts
+}
+
+func newLoggedTable() *loggedTable {
+	return &loggedTable{
+		typedTable: newTypedTable(),
+		changes: []string{
+			"create table if not exists test_structs_changes (\n    id integer primary key autoincrement,\n    op text not null\n);",
+			"create trigger if not exists test_structs_changes_insert after insert on test_structs begin\n  insert into test_structs_changes (op) values ('insert');\nend;",
+		},
+	}
+}
+
+func newIndexedTable() *indexedTable {
+	return &indexedTable{
+		loggedTable: newLoggedTable(),
+		fts: []string{
+			"create virtual table if not exists test_structs_fts using fts5(name, content='test_structs', content_rowid='id');",
+			"create trigger if not exists test_structs_fts_insert after insert on test_structs begin\n  insert into test_structs_fts(rowid, name) values (new.id, new.name);\nend;",
+		},
+	}
+}
+
+func TestCompareLogged(t *testing.T) {
+	o := newIndexedTable()
+	want := append([]string{"create table if not exists ns_test_structs (", "create index if not exists ns_idx_kind on ns_test_structs"}, namespaceAll("ns_", o.fts)...)
+	want = append(want, namespaceAll("ns_", o.changes)...)
+	got := compareTable("ns_", o, nil, nil).Statements()
+	if len(got) != len(want) {
+		t.Fatalf("got statements:\n%q\nwant:\n%q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("statement %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	live := []Column{
+		{"id", "integer"},
+		{"name", "text"},
+		{"kind", "integer"},
+		{"data", "text"},
+		{"modified", "datetime"},
+		{"legacy", "text"},
+	}
+	got = compareTable("ns_", o, live, []string{"ns_idx_kind"}).Statements()
+	want = append([]string{"drop table if exists ns_test_structs_fts"}, namespaceAll("ns_", o.fts)...)
+	want = append(want, "insert into ns_test_structs_fts(ns_test_structs_fts) values ('rebuild')")
+	want = append(want, namespaceAll("ns_", o.changes)...)
+	if len(got) < len(want) || strings.Join(got[len(got)-len(want):], ";") != strings.Join(want, ";") {
+		t.Errorf("got statements:\n%q\nwant them to end with:\n%q", got, want)
+	}
+}
+
+func TestConvergeChanges(t *testing.T) {
+	db, local := sqliteServer(t)
+	// the full text index needs sqlite built with fts5, so is left out
+	o := newLoggedTable()
+	for _, query := range append([]string{
+		"create table test_structs (id integer primary key, name text, kind integer, data text, modified datetime, legacy text)",
+	}, o.changes...) {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Converge(o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Exec("insert into test_structs (name) values ('one')"); err != nil {
+		t.Fatal(err)
+	}
+	var changes int
+	local.QueryRow("select count(*) from test_structs_changes").Scan(&changes)
+	if changes != 1 {
+		t.Errorf("the rebuilt table logged %d changes, want 1", changes)
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	tests := map[string]string{
+		"INTEGER":      "integer",
+		"bigint":       "integer",
+		"varchar(255)": "text",
+		"blob":         "blob",
+		"":             "blob",
+		"double":       "real",
+		"datetime":     "numeric",
+	}
+	for declared, want := range tests {
+		if got := affinity(declared); got != want {
+			t.Errorf("%q: got %s, want %s", declared, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package rqlobj
+
+import (
+	"strings"
+)
+
+// PlanStep is a step of a query plan, as reported by EXPLAIN QUERY PLAN
+type PlanStep struct {
+	ID       int64  // the step's id
+	Parent   int64  // the id of the step it is part of, zero at the top
+	Detail   string // the description of the step, e.g., SCAN hosts
+	Table    string // the table scanned or searched by the step, if any
+	FullScan bool   // true if every row of the table is read
+}
+
+// QueryPlan is how sqlite runs a query
+type QueryPlan struct {
+	Query string
+	Steps []PlanStep
+}
+
+// FullScans returns the tables the plan reads every row of
+func (p QueryPlan) FullScans() []string {
+	var tables []string
+	for _, step := range p.Steps {
+		if step.FullScan {
+			tables = append(tables, step.Table)
+		}
+	}
+	return tables
+}
+
+// Explain returns the plan of the query ListQuery makes for the list and
+// criteria, flagging the tables it scans in full, which usually calls for
+// an index on the columns of the criteria. Scans are also logged, if debugging
+func (db RDB) Explain(list DBList, where string) (QueryPlan, error) {
+	query, err := db.listQuery(list, where)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+	plan := QueryPlan{Query: query}
+	result, err := db.queryResult("explain query plan " + query)
+	if err != nil {
+		return plan, err
+	}
+	for result.Next() {
+		var id, parent, notused int64
+		var detail string
+		if err := scan(&result, &id, &parent, &notused, &detail); err != nil {
+			return plan, err
+		}
+		plan.Steps = append(plan.Steps, planStep(id, parent, detail))
+	}
+	if db.debug {
+		for _, table := range plan.FullScans() {
+			db.debugf("full scan of %s: %s\n", table, query)
+		}
+	}
+	return plan, nil
+}
+
+// planStep returns the step of a plan, with the table it reads, if any.
+// Older versions of sqlite write SCAN TABLE t rather than SCAN t, and
+// scans of a table by an index, virtual table, subquery or constant row
+// are not full scans of a table
+func planStep(id, parent int64, detail string) PlanStep {
+	step := PlanStep{ID: id, Parent: parent, Detail: detail}
+	words := strings.Fields(detail)
+	if len(words) < 2 || words[0] != "SCAN" && words[0] != "SEARCH" {
+		return step
+	}
+	words = words[1:]
+	if words[0] == "TABLE" && len(words) > 1 {
+		words = words[1:]
+	}
+	if words[0] == "CONSTANT" || words[0] == "SUBQUERY" || strings.HasPrefix(words[0], "(") {
+		return step
+	}
+	step.Table = words[0]
+	step.FullScan = strings.HasPrefix(detail, "SCAN ") &&
+		!strings.Contains(detail, " USING ") && !strings.Contains(detail, "VIRTUAL TABLE")
+	return step
+}
@@ -0,0 +1,63 @@
+package rqlobj
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestPlanStep(t *testing.T) {
+	for detail, want := range map[string]PlanStep{
+		"SCAN hosts":       {Table: "hosts", FullScan: true},
+		"SCAN TABLE hosts": {Table: "hosts", FullScan: true},
+		"SCAN hosts AS h":  {Table: "hosts", FullScan: true},
+		"SCAN hosts USING COVERING INDEX hosts_name":         {Table: "hosts"},
+		"SEARCH hosts USING INTEGER PRIMARY KEY (rowid=?)":   {Table: "hosts"},
+		"SEARCH TABLE hosts USING INDEX hosts_name (name=?)": {Table: "hosts"},
+		"SCAN hosts_fts VIRTUAL TABLE INDEX 0:M2":            {Table: "hosts_fts"},
+		"SCAN CONSTANT ROW":                                  {},
+		"SCAN SUBQUERY 1":                                    {},
+		"SCAN (subquery-1)":                                  {},
+		"USE TEMP B-TREE FOR ORDER BY":                       {},
+	} {
+		want.ID, want.Parent, want.Detail = 3, 2, detail
+		if got := planStep(3, 2, detail); got != want {
+			t.Errorf("%s: got %+v, want %+v", detail, got, want)
+		}
+	}
+}
+
+func TestExplainSQLite(t *testing.T) {
+	local, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	if _, err := local.Exec(queryCreate); err != nil {
+		t.Fatal(err)
+	}
+	var list _testStruct
+	for where, scans := range map[string][]string{
+		"":         {tableName},
+		"name='x'": {tableName},
+		"id=1":     nil,
+	} {
+		rows, err := local.Query("explain query plan " + list.SQLGet(where))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan QueryPlan
+		for rows.Next() {
+			var id, parent, notused int64
+			var detail string
+			if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan.Steps = append(plan.Steps, planStep(id, parent, detail))
+		}
+		rows.Close()
+		if got := plan.FullScans(); !reflect.DeepEqual(got, scans) {
+			t.Errorf("%q: got scans %v, want %v in %+v", where, got, scans, plan.Steps)
+		}
+	}
+}
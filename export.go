@@ -0,0 +1,462 @@
+package rqlobj
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// Format is the data format of exported and imported objects
+type Format int
+
+const (
+	// JSONLines is one JSON object per line, keyed by column name
+	JSONLines Format = iota
+
+	// CSV is comma separated values with a header of the column names
+	CSV
+)
+
+func (f Format) String() string {
+	switch f {
+	case JSONLines:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ImportBatch is the most objects imported in a single transaction
+const ImportBatch = 100
+
+// LineError is the error importing an object from a line of input
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
+// ImportErrors are the lines that could not be imported
+type ImportErrors []LineError
+
+func (e ImportErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d lines not imported, first %v", len(e), e[0])
+}
+
+// Export loads the list and writes its objects to w in the format, with
+// the fields named by column. The list must be a pointer to a slice of
+// objects, such as the list types generated by dbgen
+func (db RDB) Export(list DBList, w io.Writer, format Format) error {
+	create, _, err := appender(list)
+	if err != nil {
+		return err
+	}
+	if err := db.List(list); err != nil {
+		return err
+	}
+	objs, err := objectsOf(list)
+	if err != nil {
+		return err
+	}
+	return writeObjects(w, format, selectColumns(create()), objs)
+}
+
+// Import reads objects like o from r in the format and adds them in
+// batches, each in a single transaction. Fields are matched by column name.
+// Lines that cannot be read or added are skipped and returned as ImportErrors,
+// along with the number of objects imported
+func (db RDB) Import(o DBObject, r io.Reader, format Format) (int, error) {
+	if _, err := db.scope(o); err != nil {
+		return 0, err
+	}
+	var (
+		failed   ImportErrors
+		queries  []string
+		lines    []int
+		imported int
+	)
+	flush := func() error {
+		for len(queries) > 0 {
+			results, err := db.Write(queries...)
+			if err == nil {
+				imported += len(queries)
+				break
+			}
+			// drop the statement that failed and retry the rest of the batch
+			i := failedStatement(results)
+			if i < 0 {
+				return writeError(err, results, queries)
+			}
+			failed = append(failed, LineError{Line: lines[i], Err: results[i].Err})
+			queries = append(queries[:i], queries[i+1:]...)
+			lines = append(lines[:i], lines[i+1:]...)
+		}
+		queries, lines = queries[:0], lines[:0]
+		return nil
+	}
+	err := readObjects(o, r, format, func(line int, obj DBObject, err error) error {
+		if err == nil {
+			err = db.setTenant(obj)
+		}
+		if err != nil {
+			failed = append(failed, LineError{Line: line, Err: err})
+			return nil
+		}
+		queries = append(queries, importQuery(db.prefix, obj))
+		lines = append(lines, line)
+		if len(queries) < ImportBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return imported, err
+	}
+	if len(failed) > 0 {
+		return imported, failed
+	}
+	return imported, nil
+}
+
+// failedStatement returns the index of the statement that failed, if known
+func failedStatement(results []gorqlite.WriteResult) int {
+	for i, result := range results {
+		if result.Err != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// importQuery returns the statement to insert the object as is, keys included,
+// into the table named with the prefix. Zero keys, zero times and zero fields
+// with defaults are left to the database
+func importQuery(prefix string, o DBObject) string {
+	var defaults []string
+	if d, ok := o.(Defaulter); ok {
+		defaults = d.DefaultFields()
+	}
+	columns := selectColumns(o)
+	receivers := o.Receivers()
+	fields := make([]string, 0, len(columns))
+	values := make([]interface{}, 0, len(columns))
+	for i, column := range columns {
+		value := received(receivers[i])
+		zero := isZero(value)
+		if zero && (within(column, o.KeyFields()) || within(column, defaults)) {
+			continue
+		}
+		if t, ok := value.(time.Time); ok && t.IsZero() {
+			continue
+		}
+		fields = append(fields, column)
+		values = append(values, value)
+	}
+	const text = "insert into %s (%s) values(%s)"
+	return fmt.Sprintf(text, prefix+o.TableName(), join(fields), fieldList(values...))
+}
+
+// selectColumns returns the column names of the object's select fields
+func selectColumns(o DBObject) []string {
+	fields := strings.Split(o.SelectFields(), ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+	return fields
+}
+
+// received returns the value held by a receiver
+func received(r interface{}) interface{} {
+	if j, ok := r.(JSONField); ok {
+		return j
+	}
+	v := reflect.ValueOf(r)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return r
+	}
+	return v.Elem().Interface()
+}
+
+// newLike returns a new object of the same type as o
+func newLike(o DBObject) DBObject {
+	return reflect.New(reflect.TypeOf(o).Elem()).Interface().(DBObject)
+}
+
+// writeObjects writes the columns of the objects in the format
+func writeObjects(w io.Writer, format Format, columns []string, objs []DBObject) error {
+	switch format {
+	case JSONLines:
+		bw := bufio.NewWriter(w)
+		for _, o := range objs {
+			b, err := jsonLine(columns, o.Receivers())
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+			bw.WriteByte('\n')
+		}
+		return bw.Flush()
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		record := make([]string, len(columns))
+		for _, o := range objs {
+			for i, r := range o.Receivers() {
+				text, err := csvText(received(r))
+				if err != nil {
+					return fmt.Errorf("column %s: %w", columns[i], err)
+				}
+				record[i] = text
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unsupported format: %v", format)
+}
+
+// jsonLine returns the JSON object of the receivers' values, in column order
+func jsonLine(columns []string, receivers []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		value := received(receivers[i])
+		switch v := value.(type) {
+		case JSONField:
+			value = v.V
+		case time.Time:
+			if v.IsZero() {
+				value = nil
+			}
+		}
+		name, _ := json.Marshal(column)
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column, err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// csvText returns the text of the value in a CSV field
+func csvText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+		return v.Format(time.RFC3339Nano), nil
+	case JSONField:
+		return v.encode()
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// readObjects reads objects like o from r in the format, calling fn with
+// each object or the error reading it, along with its line number
+func readObjects(o DBObject, r io.Reader, format Format, fn func(int, DBObject, error) error) error {
+	switch format {
+	case JSONLines:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			obj := newLike(o)
+			if err := fn(line, obj, decodeLine(obj, text)); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	case CSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return fmt.Errorf("reading csv header: %w", err)
+		}
+		// the header is checked once, rather than failing every line
+		if _, err := columnReceivers(o, header); err != nil {
+			return err
+		}
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			line, _ := cr.FieldPos(0)
+			if err != nil {
+				if _, ok := err.(*csv.ParseError); !ok {
+					return err
+				}
+				if err := fn(line, nil, err); err != nil {
+					return err
+				}
+				continue
+			}
+			obj := newLike(o)
+			if err := fn(line, obj, decodeRecord(obj, header, record)); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("unsupported format: %v", format)
+}
+
+// decodeLine sets the object's fields from a line of JSON
+func decodeLine(o DBObject, text []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(text, &fields); err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		columns = append(columns, column)
+	}
+	dest, err := columnReceivers(o, columns)
+	if err != nil {
+		return err
+	}
+	for i, column := range columns {
+		raw := fields[column]
+		if string(raw) == "null" {
+			continue
+		}
+		target := dest[i]
+		if j, ok := target.(JSONField); ok {
+			target = j.V
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// decodeRecord sets the object's fields from a CSV record
+func decodeRecord(o DBObject, header, record []string) error {
+	dest, err := columnReceivers(o, header)
+	if err != nil {
+		return err
+	}
+	for i, text := range record {
+		// empty fields are left as zero values
+		if i >= len(dest) || text == "" {
+			continue
+		}
+		if err := parseText(dest[i], text); err != nil {
+			return fmt.Errorf("column %s: %w", header[i], err)
+		}
+	}
+	return nil
+}
+
+// parseText sets the receiver to the value of its text
+func parseText(dest interface{}, text string) error {
+	switch d := dest.(type) {
+	case JSONField:
+		return d.decode(text)
+	case *string:
+		*d = text
+		return nil
+	case *[]byte:
+		b, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return err
+		}
+		*d = b
+		return nil
+	case *time.Time:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			if t, err = toTime(text); err != nil {
+				return err
+			}
+		}
+		*d = t
+		return nil
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("invalid receiver: %T", dest)
+	}
+	elem := v.Elem()
+	switch elem.Kind() {
+	case reflect.String:
+		elem.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		elem.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, elem.Type().Bits())
+		if err != nil {
+			return err
+		}
+		elem.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 10, elem.Type().Bits())
+		if err != nil {
+			return err
+		}
+		elem.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, elem.Type().Bits())
+		if err != nil {
+			return err
+		}
+		elem.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported receiver: %T", dest)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package rqlobj
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exported(t *testing.T, format Format) (string, []*testStruct) {
+	t.Helper()
+	when := time.Date(2019, 9, 11, 19, 54, 37, 0, time.UTC)
+	objs := []*testStruct{
+		{ID: 1, Name: "one, with a comma", Kind: 2, Data: `say "hi"`, Modified: when},
+		{ID: 2, Name: "two"},
+	}
+	var buf bytes.Buffer
+	list := []DBObject{objs[0], objs[1]}
+	if err := writeObjects(&buf, format, selectColumns(objs[0]), list); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), objs
+}
+
+func TestExport(t *testing.T) {
+	text, _ := exported(t, JSONLines)
+	const jsonl = `{"id":1,"name":"one, with a comma","kind":2,"data":"say \"hi\"","modified":"2019-09-11T19:54:37Z"}
+{"id":2,"name":"two","kind":0,"data":"","modified":null}
+`
+	if text != jsonl {
+		t.Errorf("got %s\nwant %s", text, jsonl)
+	}
+	text, _ = exported(t, CSV)
+	const csv = `id,name,kind,data,modified
+1,"one, with a comma",2,"say ""hi""",2019-09-11T19:54:37Z
+2,two,0,,
+`
+	if text != csv {
+		t.Errorf("got %s\nwant %s", text, csv)
+	}
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	for _, format := range []Format{JSONLines, CSV} {
+		text, objs := exported(t, format)
+		var got []*testStruct
+		err := readObjects(&testStruct{}, strings.NewReader(text), format, func(line int, o DBObject, err error) error {
+			if err != nil {
+				t.Errorf("%v line %d: %v", format, line, err)
+				return nil
+			}
+			got = append(got, o.(*testStruct))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(objs) {
+			t.Fatalf("%v: expected %d objects but got %d", format, len(objs), len(got))
+		}
+		for i, o := range objs {
+			if err := o.equal(got[i]); err != nil || !o.Modified.Equal(got[i].Modified) {
+				t.Errorf("%v: object %d: %v %v", format, i, err, got[i].Modified)
+			}
+		}
+	}
+}
+
+func TestImportLineErrors(t *testing.T) {
+	const jsonl = `{"id":1,"name":"one"}
+
+{"id":"two"}
+{"id":3,"nope":true}
+{"id":4}
+`
+	var lines []int
+	var good int
+	err := readObjects(&testStruct{}, strings.NewReader(jsonl), JSONLines, func(line int, o DBObject, err error) error {
+		if err != nil {
+			lines = append(lines, line)
+		} else {
+			good++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if good != 2 || len(lines) != 2 || lines[0] != 3 || lines[1] != 4 {
+		t.Errorf("expected errors on lines 3 and 4 but got %v (%d good)", lines, good)
+	}
+	const csv = "id,name,kind\n1,one,1\n2,two,many\n"
+	lines = nil
+	err = readObjects(&testStruct{}, strings.NewReader(csv), CSV, func(line int, o DBObject, err error) error {
+		if err != nil {
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != 3 {
+		t.Errorf("expected an error on line 3 but got %v", lines)
+	}
+	if err := readObjects(&testStruct{}, strings.NewReader("id,nope\n"), CSV, nil); err == nil {
+		t.Errorf("expected error for an unknown column in the header")
+	}
+}
+
+func TestImportQuery(t *testing.T) {
+	const query = "insert into test_structs (name,kind,data) values('new', 1, '')"
+	if got := importQuery("", &testStruct{Name: "new", Kind: 1}); got != query {
+		t.Errorf("got %s\nwant %s", got, query)
+	}
+	const keyed = "insert into test_structs (id,name,kind,data) values(9, 'nine', 0, '')"
+	if got := importQuery("", &testStruct{ID: 9, Name: "nine"}); got != keyed {
+		t.Errorf("got %s\nwant %s", got, keyed)
+	}
+}
@@ -1,10 +1,9 @@
 module github.com/paulstuart/rqlobj
 
-go 1.12
+go 1.17
 
 require (
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/pkg/errors v0.8.1
 	github.com/rqlite/gorqlite v0.0.0-20190911195437-7476ee0ed9ea
-	github.com/rqlite/rqlite v4.5.0+incompatible
 )
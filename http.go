@@ -0,0 +1,226 @@
+package rqlobj
+
+// The db talks to rqlite's http api itself rather than through a gorqlite
+// connection, whose http client is unexported and built from the url alone.
+// With it, TLS, basic auth, proxies and timeouts could only be configured by
+// replacing http.DefaultTransport for the whole process. Here every request
+// goes through the client of the db's Config, and gorqlite is only kept for
+// the results returned by Write.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRedirects is the most redirects followed by a request, as by the http package
+const maxRedirects = 10
+
+// leaderCache holds the api host of the cluster leader, once learned,
+// shared by the copies of a db
+type leaderCache struct {
+	mu   sync.Mutex
+	host string
+}
+
+// get returns the host of the leader, if known
+func (c *leaderCache) get() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.host
+}
+
+// set records the host of the leader
+func (c *leaderCache) set(host string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.host = host
+	c.mu.Unlock()
+}
+
+// forget clears the host of the leader, if it is the host given
+func (c *leaderCache) forget(host string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.host == host {
+		c.host = ""
+	}
+	c.mu.Unlock()
+}
+
+// endpoint returns the url of the api path on the host connected to, or for
+// operations on the cluster leader, on the leader if it is known
+func (db RDB) endpoint(path string, query url.Values, leader bool) (string, error) {
+	if db.base == nil {
+		return "", fmt.Errorf("no cluster url for %s", path)
+	}
+	u := *db.base
+	u.User = nil
+	if host := db.leader.get(); leader && host != "" {
+		u.Host = host
+	}
+	u.Path = path
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// apiHost returns the host and port of a node's api address,
+// which later versions of rqlite give as a url
+func apiHost(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return addr
+}
+
+// httpClient returns the client for the api endpoints, which leaves
+// redirects to apiRequest, as the http package only follows those of
+// posts as gets, dropping the statements they carry
+func (db RDB) httpClient() *http.Client {
+	client := *http.DefaultClient
+	if db.client != nil {
+		client = *db.client
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+// apiRequest sends the request to the api path, following redirects
+// to the leader, returning the response if successful, which the
+// caller must close. Operations on the leader are sent to it once it
+// is learned from their redirects, which change it
+func (db RDB) apiRequest(method, path string, query url.Values, body io.Reader, header http.Header, leader bool) (*http.Response, error) {
+	endpoint, err := db.endpoint(path, query, leader)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if user := db.base.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	if leader && req.Body != nil && req.GetBody == nil && db.leader.get() == "" {
+		// the body cannot be resent if redirected, so the leader is found first
+		if node, err := db.Leader(); err == nil && node.APIAddr != "" {
+			req.URL.Host = apiHost(node.APIAddr)
+			db.leader.set(req.URL.Host)
+		}
+	}
+	db.debugf("%s %s\n", method, path)
+	client := db.httpClient()
+	var resp *http.Response
+	for redirects := 0; ; redirects++ {
+		start := time.Now()
+		if resp, err = client.Do(req); err != nil {
+			if leader {
+				// the leader may have gone, so is found again
+				db.leader.forget(req.URL.Host)
+			}
+			return nil, err
+		}
+		db.tracef("%s %s%s: %s (%s)\n", method, req.URL.Host, req.URL.Path, resp.Status, time.Since(start))
+		location := resp.Header.Get("Location")
+		if resp.StatusCode/100 != 3 || location == "" {
+			break
+		}
+		resp.Body.Close()
+		if redirects == maxRedirects {
+			return nil, fmt.Errorf("%s %s: stopped after %d redirects", method, path, maxRedirects)
+		}
+		if req, err = redirected(req, location); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+		if leader {
+			db.leader.set(req.URL.Host)
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// redirected returns the request resent to the location, with its body
+func redirected(req *http.Request, location string) (*http.Request, error) {
+	u, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("redirected to %q: %w", location, err)
+	}
+	var body io.ReadCloser
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("cannot resend the body to %s", u.Host)
+		}
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	next, err := http.NewRequest(req.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	next.GetBody = req.GetBody
+	next.ContentLength = req.ContentLength
+	next.Header = req.Header.Clone()
+	return next, nil
+}
+
+// tracef logs a request, if tracing
+func (db RDB) tracef(msg string, args ...interface{}) {
+	if db.trace != nil {
+		db.trace.Printf(msg, args...)
+	}
+}
+
+// apiResults is the reply of the api endpoints that run statements
+type apiResults struct {
+	Results []struct {
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// err returns the first error reported, if any
+func (r apiResults) err() error {
+	if r.Error != "" {
+		return fmt.Errorf("%s", r.Error)
+	}
+	for _, result := range r.Results {
+		if result.Error != "" {
+			return fmt.Errorf("%s", result.Error)
+		}
+	}
+	return nil
+}
+
+// decodeResults returns the first error reported in the api reply
+func decodeResults(r io.Reader) error {
+	var results apiResults
+	if err := json.NewDecoder(r).Decode(&results); err != nil && err != io.EOF {
+		return fmt.Errorf("decoding reply: %w", err)
+	}
+	return results.err()
+}
@@ -0,0 +1,102 @@
+package rqlobj
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAPIRequestRedirect(t *testing.T) {
+	var got string
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/db/execute":
+			http.Redirect(w, r, "/leader"+r.URL.RequestURI(), http.StatusMovedPermanently)
+		case "/leader/db/execute":
+			b, _ := ioutil.ReadAll(r.Body)
+			got = r.Method + " " + r.URL.RawQuery + " " + string(b)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusTemporaryRedirect)
+		default:
+			http.Error(w, "no such endpoint", http.StatusNotFound)
+		}
+	})
+	db.base.User = url.UserPassword("admin", "secret")
+
+	query := url.Values{"timings": []string{""}}
+	resp, err := db.apiRequest("POST", "/db/execute", query, strings.NewReader(`["select 1"]`), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := `POST timings= ["select 1"]`; got != want {
+		t.Errorf("redirected request: got %q, want %q", got, want)
+	}
+
+	// a body that cannot be resent is not sent again
+	body := ioutil.NopCloser(strings.NewReader("x"))
+	if _, err := db.apiRequest("POST", "/db/execute", nil, body, nil, false); err == nil || !strings.Contains(err.Error(), "cannot resend") {
+		t.Errorf("expected the body not to be resent but got %v", err)
+	}
+	if _, err := db.apiRequest("GET", "/loop", nil, nil, nil, false); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("expected too many redirects but got %v", err)
+	}
+	if _, err := db.apiRequest("GET", "/nowhere", nil, nil, nil, false); err == nil || !strings.Contains(err.Error(), "404 Not Found: no such endpoint") {
+		t.Errorf("expected the status and reply but got %v", err)
+	}
+}
+
+func TestLeaderCache(t *testing.T) {
+	requests := make(map[string]int)
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests["leader "+r.URL.Path]++
+		w.Write([]byte(`{"results": [{}]}`))
+	}))
+	t.Cleanup(leader.Close)
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests["follower "+r.URL.Path]++
+		if r.URL.Path == "/nodes" {
+			fmt.Fprintf(w, `{"nodes": [{"id": "node1", "api_addr": %q, "leader": true}]}`, leader.URL)
+			return
+		}
+		http.Redirect(w, r, leader.URL+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	// the leader is learned from the redirect, without asking for the nodes
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := db.Backup(&buf, BackupSQL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests["follower /db/backup"] != 1 || requests["leader /db/backup"] != 2 || requests["follower /nodes"] != 0 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// a body that cannot be resent is sent to the leader found first
+	db.leader.set("")
+	if err := db.Restore(strings.NewReader("create table t (id integer);")); err != nil {
+		t.Fatal(err)
+	}
+	if requests["follower /nodes"] != 1 || requests["follower /db/load"] != 0 || requests["leader /db/load"] != 1 {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	// a leader that cannot be reached is forgotten
+	leader.Close()
+	if err := db.Restore(strings.NewReader("select 1;")); err == nil {
+		t.Fatal("expected the closed leader to fail")
+	}
+	if host := db.leader.get(); host != "" {
+		t.Errorf("unreachable leader %s kept", host)
+	}
+}
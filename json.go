@@ -0,0 +1,70 @@
+package rqlobj
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONField wraps a struct, map or slice member that is stored
+// in the database as JSON text. dbgen wraps members tagged
+// with the json option, e.g., `sql:"tags,json"`
+type JSONField struct {
+	V interface{}
+}
+
+// JSON returns a JSONField for v. When inserting or updating v is
+// the value to encode, when scanning it must be a pointer to the member
+func JSON(v interface{}) JSONField {
+	return JSONField{V: v}
+}
+
+// encode returns the JSON text of the wrapped value
+func (j JSONField) encode() (string, error) {
+	b, err := json.Marshal(j.V)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// jsonError returns the error of the first JSON field among
+// the values that cannot be encoded, so that it is not written as null
+func jsonError(values ...interface{}) error {
+	for _, value := range values {
+		if j, ok := value.(JSONField); ok {
+			if _, err := j.encode(); err != nil {
+				return fmt.Errorf("encoding json: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// decode unmarshals the JSON text into the wrapped pointer
+func (j JSONField) decode(text string) error {
+	if text == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(text), j.V); err != nil {
+		return fmt.Errorf("decoding json %q: %w", text, err)
+	}
+	return nil
+}
+
+// JSONPath returns the expression to extract the element at path from
+// the JSON column, for use as a key in Load or in ListQuery criteria.
+// e.g., JSONPath("attrs", "owner.name") yields json_extract(attrs, '$.owner.name')
+func JSONPath(column, path string) string {
+	if !strings.HasPrefix(path, "$") {
+		path = "$." + path
+	}
+	return fmt.Sprintf("json_extract(%s, %s)", column, formatted(path))
+}
+
+// JSONContains returns the criteria for rows where the JSON array
+// stored in column holds the given value
+func JSONContains(column string, value interface{}) string {
+	const text = "exists (select 1 from json_each(%s) where json_each.value = %s)"
+	return fmt.Sprintf(text, column, formatted(value))
+}
@@ -0,0 +1,82 @@
+package rqlobj
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestJSONFormatted(t *testing.T) {
+	type attrs struct {
+		Owner string `json:"owner"`
+	}
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{[]string{"a", "b'c"}, `'["a","b''c"]'`},
+		{attrs{Owner: "bob"}, `'{"owner":"bob"}'`},
+		{[]string(nil), "null"},
+	}
+	for _, tt := range tests {
+		if got := formatted(JSON(tt.in)); got != tt.want {
+			t.Errorf("formatted(%v): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	var back attrs
+	if err := JSON(&back).decode(`{"owner":"bob"}`); err != nil {
+		t.Fatal(err)
+	}
+	if back.Owner != "bob" {
+		t.Errorf("decoded owner: %q", back.Owner)
+	}
+}
+
+func TestJSONCriteria(t *testing.T) {
+	const path = "json_extract(attrs, '$.owner.name')"
+	if got := JSONPath("attrs", "owner.name"); got != path {
+		t.Errorf("got %s, want %s", got, path)
+	}
+	const contains = "exists (select 1 from json_each(tags) where json_each.value = 'red')"
+	if got := JSONContains("tags", "red"); got != contains {
+		t.Errorf("got %s, want %s", got, contains)
+	}
+}
+
+// jsonStruct stores its attributes as JSON in the data column
+type jsonStruct struct {
+	testStruct
+	attrs interface{}
+}
+
+func (s *jsonStruct) Receivers() []interface{} {
+	return []interface{}{&s.ID, &s.Name, &s.Kind, JSON(&s.attrs), &s.Modified}
+}
+
+func (s *jsonStruct) InsertValues() []interface{} {
+	return []interface{}{s.Name, s.Kind, JSON(s.attrs)}
+}
+
+func TestJSONWriteError(t *testing.T) {
+	var written int
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		written++
+		w.Write([]byte(`{"results": [{"rows_affected": 1}]}`))
+	})
+	o := &jsonStruct{testStruct: testStruct{ID: 1}, attrs: map[string]interface{}{"bad": make(chan int)}}
+	var unsupported *json.UnsupportedTypeError
+	if err := db.Add(o); !errors.As(err, &unsupported) {
+		t.Errorf("expected the encoding error but got %v", err)
+	}
+	if err := db.Update(o); !errors.As(err, &unsupported) {
+		t.Errorf("expected the encoding error but got %v", err)
+	}
+	if written != 0 {
+		t.Errorf("wrote %d times, want the writes refused", written)
+	}
+	o.attrs = map[string]interface{}{"good": 1}
+	if err := db.Update(o); err != nil || written != 1 {
+		t.Errorf("update not written: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// migration files are named as version_name.up.sql and version_name.down.sql
+var sqlFile = regexp.MustCompile(`^(\d+)_(.*)\.(up|down)\.sql$`)
+
+// FromFS returns the migrations defined by the .sql files in the directory,
+// e.g., 0001_create_users.up.sql and 0001_create_users.down.sql.
+// Each file is run as a single write, so it may contain several statements
+func FromFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	var versions []int64
+	for _, entry := range entries {
+		match := sqlFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+			versions = append(versions, version)
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is named both %q and %q", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.Up = SQL(string(b))
+		} else {
+			mig.Down = SQL(string(b))
+		}
+	}
+	list := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		mig := byVersion[version]
+		if mig.Up == nil {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", version, mig.Name)
+		}
+		list = append(list, *mig)
+	}
+	return list, nil
+}
@@ -0,0 +1,348 @@
+// Package migrations applies versioned schema changes to an rqlite
+// cluster through an rqlobj.RDB.
+//
+// Each migration has a unique version number and a pair of steps,
+// one to apply it and one to revert it. The statements of a step are
+// written in a single transaction along with the bookkeeping of the
+// schema_migrations table, so a migration is either applied in full
+// or not at all.
+//
+// On an RDB with a namespace, the migrations are tracked by the tables of
+// that namespace, e.g., billing_schema_migrations. The statements of the
+// steps are written as given, and can use RDB.Namespaced for their tables.
+package migrations
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulstuart/rqlobj"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Table tracks the migrations applied
+	Table = "schema_migrations"
+
+	// LockTable holds the lock taken while migrating
+	LockTable = "schema_migrations_lock"
+
+	// DefaultLockTTL is how long a lock is honored before it is
+	// considered abandoned by a migrator that died while holding it
+	DefaultLockTTL = 10 * time.Minute
+)
+
+var (
+	// ErrLocked is returned when another migrator holds the lock
+	ErrLocked = errors.New("migrations are locked by another process")
+
+	// ErrNoDown is returned when reverting a migration without a down step
+	ErrNoDown = errors.New("migration has no down step")
+
+	// ErrUnknownVersion is returned when migrating to a version that is not defined
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// Func returns the statements of a migration step. The RDB is
+// provided for steps that need to read data to build them
+type Func func(db rqlobj.RDB) ([]string, error)
+
+// SQL returns a Func that returns the given statements
+func SQL(statements ...string) Func {
+	return func(_ rqlobj.RDB) ([]string, error) {
+		return statements, nil
+	}
+}
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      Func
+	Down    Func
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         rqlobj.RDB
+	migrations []Migration
+
+	// Owner identifies the migrator holding the lock,
+	// it defaults to the hostname and process id
+	Owner string
+
+	// LockTTL is how long the lock is honored
+	LockTTL time.Duration
+}
+
+// New returns a Migrator for the migrations, which must have unique versions
+func New(db rqlobj.RDB, migrations ...Migration) (*Migrator, error) {
+	list := make([]Migration, len(migrations))
+	copy(list, migrations)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	for i, m := range list {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q: version must be positive", m.Name)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d (%s) has no up step", m.Version, m.Name)
+		}
+		if i > 0 && list[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration version %d is used more than once", m.Version)
+		}
+	}
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: list,
+		Owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+		LockTTL:    DefaultLockTTL,
+	}, nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up() error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down() error {
+	return m.locked(func(applied map[int64]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.revert(m.migrations[i])
+			}
+		}
+		return nil
+	})
+}
+
+// To applies or reverts migrations so that those up to and including
+// version are applied and any later ones are not. A version of zero
+// reverts all migrations
+func (m *Migrator) To(version int64) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.locked(func(applied map[int64]time.Time) error {
+		up, down := plan(m.migrations, applied, version)
+		for _, mig := range down {
+			if err := m.revert(mig); err != nil {
+				return err
+			}
+		}
+		for _, mig := range up {
+			if err := m.apply(mig); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the state of each migration, in version order
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.bootstrap(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		list[i] = Status{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: at,
+		}
+	}
+	return list, nil
+}
+
+// plan returns the migrations to apply, in ascending order,
+// and those to revert, in descending order, to reach the version
+func plan(migrations []Migration, applied map[int64]time.Time, version int64) (up, down []Migration) {
+	for _, mig := range migrations {
+		_, ok := applied[mig.Version]
+		if mig.Version <= version && !ok {
+			up = append(up, mig)
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if _, ok := applied[mig.Version]; ok && mig.Version > version {
+			down = append(down, mig)
+		}
+	}
+	return up, down
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply runs the up step of the migration and records it, in one transaction
+func (m *Migrator) apply(mig Migration) error {
+	statements, err := mig.Up(m.db)
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+	}
+	const text = "insert into %s (version, name, applied_at) values(%d, '%s', %d)"
+	record := m.db.Namespaced(fmt.Sprintf(text, Table, mig.Version, quote(mig.Name), time.Now().Unix()))
+	return m.write(mig, append(statements[:len(statements):len(statements)], record))
+}
+
+// revert runs the down step of the migration and removes its record, in one transaction
+func (m *Migrator) revert(mig Migration) error {
+	if mig.Down == nil {
+		return fmt.Errorf("%w: %d (%s)", ErrNoDown, mig.Version, mig.Name)
+	}
+	statements, err := mig.Down(m.db)
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+	}
+	record := m.db.Namespaced(fmt.Sprintf("delete from %s where version=%d", Table, mig.Version))
+	return m.write(mig, append(statements[:len(statements):len(statements)], record))
+}
+
+func (m *Migrator) write(mig Migration, statements []string) error {
+	results, err := m.db.Write(statements...)
+	if err == nil {
+		return nil
+	}
+	for i, result := range results {
+		if result.Err != nil && i < len(statements) {
+			return fmt.Errorf("migration %d (%s): %v: %s", mig.Version, mig.Name, result.Err, statements[i])
+		}
+	}
+	return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+}
+
+// bootstrap creates the tables used to track migrations
+func (m *Migrator) bootstrap() error {
+	_, err := m.db.Write(
+		m.db.Namespaced(`create table if not exists `+Table+` (
+  version integer primary key,
+  name text,
+  applied_at integer
+);`),
+		m.db.Namespaced(`create table if not exists `+LockTable+` (
+  id integer primary key check (id = 1),
+  owner text,
+  acquired integer
+);`),
+	)
+	return err
+}
+
+// locked runs fn with the set of applied migrations while holding the migration lock.
+// Failing to release the lock is reported, as it blocks migrations until it expires
+func (m *Migrator) locked(fn func(map[int64]time.Time) error) (err error) {
+	if err := m.bootstrap(); err != nil {
+		return err
+	}
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := m.unlock(); uerr != nil {
+			if err != nil {
+				err = fmt.Errorf("%w (releasing migration lock: %v)", err, uerr)
+			} else {
+				err = fmt.Errorf("releasing migration lock: %w", uerr)
+			}
+		}
+	}()
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+// lock takes the migration lock, clearing any that has expired
+func (m *Migrator) lock() error {
+	now := time.Now()
+	expired := now.Add(-m.LockTTL).Unix()
+	const text = "insert into %s (id, owner, acquired) values(1, '%s', %d)"
+	results, err := m.db.Write(
+		m.db.Namespaced(fmt.Sprintf("delete from %s where acquired < %d", LockTable, expired)),
+		m.db.Namespaced(fmt.Sprintf(text, LockTable, quote(m.Owner), now.Unix())),
+	)
+	if err != nil {
+		if len(results) > 1 && results[1].Err != nil {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *Migrator) unlock() error {
+	const text = "delete from %s where owner='%s'"
+	_, err := m.db.Write(m.db.Namespaced(fmt.Sprintf(text, LockTable, quote(m.Owner))))
+	return err
+}
+
+// applied returns the versions applied and when
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	var list appliedList
+	if err := m.db.List(&list); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]time.Time, len(list))
+	for _, row := range list {
+		applied[row.version] = time.Unix(row.appliedAt, 0)
+	}
+	return applied, nil
+}
+
+// appliedRow is a row of the migrations table
+type appliedRow struct {
+	version   int64
+	appliedAt int64
+}
+
+// appliedList is the DBList of the rows in the migrations table
+type appliedList []appliedRow
+
+func (a *appliedList) SQLGet(extra string) string {
+	return "select version, applied_at from " + Table + " " + extra + ";"
+}
+
+func (a *appliedList) SQLResults(fn func(...interface{}) error) error {
+	var row appliedRow
+	if err := fn(&row.version, &row.appliedAt); err != nil {
+		return err
+	}
+	*a = append(*a, row)
+	return nil
+}
+
+func quote(s string) string {
+	return strings.Replace(s, "'", "''", -1)
+}
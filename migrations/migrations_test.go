@@ -0,0 +1,238 @@
+package migrations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/paulstuart/rqlobj"
+)
+
+// db is an unconnected handle, enough for planning migrations
+var db rqlobj.RDB
+
+func versions(list []Migration) []int64 {
+	v := make([]int64, len(list))
+	for i, m := range list {
+		v[i] = m.Version
+	}
+	return v
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPlan(t *testing.T) {
+	var list []Migration
+	for v := int64(1); v <= 5; v++ {
+		list = append(list, Migration{Version: v})
+	}
+	applied := map[int64]time.Time{1: {}, 2: {}, 4: {}}
+	tests := []struct {
+		version  int64
+		up, down []int64
+	}{
+		{5, []int64{3, 5}, nil},
+		{3, []int64{3}, []int64{4}},
+		{1, nil, []int64{4, 2}},
+		{0, nil, []int64{4, 2, 1}},
+	}
+	for _, tt := range tests {
+		up, down := plan(list, applied, tt.version)
+		if !equal(versions(up), tt.up) || !equal(versions(down), tt.down) {
+			t.Errorf("to %d: got up %v down %v, want up %v down %v",
+				tt.version, versions(up), versions(down), tt.up, tt.down)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	up := SQL("select 1")
+	if _, err := New(db, Migration{Version: 1, Up: up}, Migration{Version: 1, Up: up}); err == nil {
+		t.Error("expected error for duplicate versions")
+	}
+	if _, err := New(db, Migration{Version: 2}); err == nil {
+		t.Error("expected error for missing up step")
+	}
+	m, err := New(db, Migration{Version: 2, Up: up}, Migration{Version: 1, Up: up})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(m.migrations), []int64{1, 2}) {
+		t.Errorf("migrations not sorted: %v", versions(m.migrations))
+	}
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_add_email.up.sql":      {Data: []byte("alter table users add column email text;")},
+		"sql/0001_create_users.up.sql":   {Data: []byte("create table users (id integer primary key);")},
+		"sql/0001_create_users.down.sql": {Data: []byte("drop table users;")},
+		"sql/README":                     {Data: []byte("not a migration")},
+	}
+	list, err := FromFS(fsys, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(versions(list), []int64{1, 2}) {
+		t.Fatalf("got versions %v", versions(list))
+	}
+	if list[0].Name != "create_users" || list[0].Down == nil {
+		t.Errorf("bad first migration: %+v", list[0])
+	}
+	if list[1].Down != nil {
+		t.Errorf("second migration should have no down step")
+	}
+	statements, _ := list[1].Up(db)
+	if len(statements) != 1 || statements[0] != "alter table users add column email text;" {
+		t.Errorf("bad statements: %q", statements)
+	}
+}
+
+func TestUnlockError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var statements []string
+		json.NewDecoder(r.Body).Decode(&statements)
+		switch {
+		case r.URL.Path == "/status":
+			fmt.Fprint(w, `{"store": {"leader": "raft1"}}`)
+		case r.URL.Path == "/db/query" && strings.HasPrefix(statements[0], "pragma"):
+			fmt.Fprint(w, `{"results": [{"columns": ["foreign_keys"], "types": ["integer"], "values": [[1]]}]}`)
+		case r.URL.Path == "/db/query":
+			fmt.Fprint(w, `{"results": [{"columns": ["version", "name", "applied_at"], "types": ["integer", "text", "integer"]}]}`)
+		case strings.HasPrefix(statements[0], "delete from "+LockTable+" where owner"):
+			fmt.Fprint(w, `{"results": [{"error": "disk I/O error"}]}`)
+		default:
+			fmt.Fprint(w, `{"results": [{}, {}]}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	db, err := rqlobj.NewRqliteConfig(rqlobj.Config{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(); err == nil || !strings.Contains(err.Error(), "releasing migration lock") {
+		t.Errorf("expected the lock not released to be reported but got %v", err)
+	}
+}
+
+// sqliteCluster returns an RDB whose statements are run by a local
+// sqlite database, standing in for a single node cluster, and that database
+func sqliteCluster(t *testing.T) (rqlobj.RDB, *sql.DB) {
+	t.Helper()
+	local, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SetMaxOpenConns(1)
+	t.Cleanup(func() { local.Close() })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		var statements []string
+		json.NewDecoder(r.Body).Decode(&statements)
+		type result struct {
+			Columns []string        `json:"columns,omitempty"`
+			Values  [][]interface{} `json:"values,omitempty"`
+			Error   string          `json:"error,omitempty"`
+		}
+		var results []result
+		local.Exec("begin")
+		for _, statement := range statements {
+			var res result
+			if r.URL.Path == "/db/query" {
+				rows, err := local.Query(statement)
+				if err != nil {
+					res.Error = err.Error()
+				} else {
+					res.Columns, _ = rows.Columns()
+					for rows.Next() {
+						row := make([]interface{}, len(res.Columns))
+						dest := make([]interface{}, len(row))
+						for i := range row {
+							dest[i] = &row[i]
+						}
+						rows.Scan(dest...)
+						res.Values = append(res.Values, row)
+					}
+					rows.Close()
+				}
+			} else if _, err := local.Exec(statement); err != nil {
+				res.Error = err.Error()
+			}
+			results = append(results, res)
+			if res.Error != "" {
+				break
+			}
+		}
+		if len(results) < len(statements) {
+			local.Exec("rollback")
+		} else {
+			local.Exec("commit")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	t.Cleanup(server.Close)
+	db, err := rqlobj.NewRqliteConfig(rqlobj.Config{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, local
+}
+
+func TestNamespaced(t *testing.T) {
+	db, local := sqliteCluster(t)
+	ns := db.WithNamespace("billing")
+	m, err := New(ns, Migration{
+		Version: 1,
+		Name:    "create users",
+		Up:      SQL(ns.Namespaced("create table users (id integer primary key)")),
+		Down:    SQL(ns.Namespaced("drop table users")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || !status[0].Applied {
+		t.Errorf("migration not applied: %+v", status)
+	}
+	var tables string
+	local.QueryRow("select group_concat(name) from (select name from sqlite_master where type = 'table' order by name)").Scan(&tables)
+	if tables != "billing_schema_migrations,billing_schema_migrations_lock,billing_users" {
+		t.Errorf("unexpected tables: %s", tables)
+	}
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := m.Status(); err != nil || status[0].Applied {
+		t.Errorf("migration not reverted: %+v (%v)", status, err)
+	}
+}
@@ -0,0 +1,259 @@
+package rqlobj
+
+import (
+	"strings"
+)
+
+// WithNamespace returns a copy of the db whose tables are prefixed with the
+// namespace and an underscore, e.g., billing_hosts, so that services sharing
+// a cluster can use the same objects without their tables colliding.
+// The tables, and the indexes, triggers and foreign keys of the statements
+// generated by dbgen, are prefixed in all the queries the db makes, including
+// those following from and join in the criteria given to ListQuery, along
+// with the columns they qualify, e.g., hosts.id. Queries
+// run with QueryInto, QueryRows, QueryMaps and Write are left as is, and
+// can use Table or Namespaced for the names of the namespaced tables
+func (db RDB) WithNamespace(namespace string) RDB {
+	db.prefix = ""
+	if namespace != "" {
+		db.prefix = namespace + "_"
+	}
+	return db
+}
+
+// Table returns the name of the object's table in the db's namespace
+func (db RDB) Table(o DBObject) string {
+	return db.prefix + o.TableName()
+}
+
+// Namespaced returns the query with its tables in the db's namespace,
+// as in the queries the db makes, for those run with Write and the like
+func (db RDB) Namespaced(query string) string {
+	return namespaceSQL(db.prefix, query)
+}
+
+// tableWords are the words followed by the name of a table,
+// or in create statements, the name of an index or trigger
+var tableWords = map[string]bool{
+	"from":       true,
+	"join":       true,
+	"into":       true,
+	"update":     true,
+	"table":      true,
+	"exists":     true,
+	"references": true,
+	"index":      true,
+	"trigger":    true,
+}
+
+// sqlWords are the words that may follow tableWords, but are never table names
+var sqlWords = map[string]bool{
+	"abort":    true,
+	"action":   true,
+	"as":       true,
+	"begin":    true,
+	"cascade":  true,
+	"conflict": true,
+	"default":  true,
+	"delete":   true,
+	"exists":   true,
+	"fail":     true,
+	"if":       true,
+	"ignore":   true,
+	"insert":   true,
+	"no":       true,
+	"not":      true,
+	"null":     true,
+	"of":       true,
+	"on":       true,
+	"or":       true,
+	"replace":  true,
+	"restrict": true,
+	"rollback": true,
+	"select":   true,
+	"set":      true,
+	"update":   true,
+	"values":   true,
+	"where":    true,
+}
+
+// clauseWords are the words that end the tables of a from clause
+var clauseWords = map[string]bool{
+	"cross":     true,
+	"except":    true,
+	"group":     true,
+	"having":    true,
+	"indexed":   true,
+	"inner":     true,
+	"intersect": true,
+	"join":      true,
+	"left":      true,
+	"limit":     true,
+	"natural":   true,
+	"not":       true,
+	"on":        true,
+	"order":     true,
+	"outer":     true,
+	"right":     true,
+	"union":     true,
+	"using":     true,
+	"where":     true,
+	"window":    true,
+}
+
+// namespaceSQL returns the query with the names of the tables it refers to
+// prefixed, as well as the names of the indexes and triggers it creates.
+// Names are recognized by the words they follow, so that columns sharing
+// the name of a table are left alone, along with the command column of an
+// FTS5 table, i.e., insert into t(t, ...), and the content option of one.
+// The tables of comma joins are recognized as those of the from clauses they
+// follow. The tables named also qualify columns, e.g., hosts.id, which are
+// prefixed in turn
+func namespaceSQL(prefix, query string) string {
+	if prefix == "" || query == "" {
+		return query
+	}
+	_, tables := namespaceTables(prefix, query, nil)
+	namespaced, _ := namespaceTables(prefix, query, tables)
+	return namespaced
+}
+
+// namespaceTables returns the query with the names of its tables prefixed,
+// and of the qualifiers of its columns that are among the tables given,
+// along with the tables it names, lowercased
+func namespaceTables(prefix, query string, tables map[string]bool) (string, map[string]bool) {
+	named := make(map[string]bool)
+	create := strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "create")
+	var b strings.Builder
+	var prev, prev2 string // the last tokens, with words lowercased
+	var table string       // the table named by the last token, if any
+	var before string      // the table named just before the last "(", if any
+	var list bool          // within the tables of a from clause, separated by commas
+	for i := 0; i < len(query); {
+		c := query[i]
+		var token, name string
+		var quoted bool
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			b.WriteByte(c)
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			b.WriteString(query[i : i+j])
+			i += j
+			continue
+		case c == '\'':
+			j := i + 1
+			for j < len(query) {
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					j++
+					break
+				}
+				j++
+			}
+			token = query[i:j]
+		case c == '"' || c == '`':
+			j := strings.IndexByte(query[i+1:], c)
+			if j < 0 {
+				token = query[i:]
+				break
+			}
+			token = query[i : i+j+2]
+			name, quoted = query[i+1:i+j+1], true
+		case isWordByte(c):
+			j := i + 1
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			token = query[i:j]
+			if c < '0' || c > '9' {
+				name = token
+			}
+		default:
+			token = query[i : i+1]
+		}
+		i += len(token)
+		if c == '\'' && prev == "=" && prev2 == "content" {
+			// the table of an FTS5 table's content option
+			token = "'" + prefix + token[1:]
+		}
+		this := ""
+		after := prev
+		if prev == "," && list {
+			// the next table of a comma join
+			after = "from"
+		}
+		qualifier := name != "" && prev != "." && strings.HasPrefix(query[i:], ".") && tables[strings.ToLower(name)]
+		if name != "" && (qualifier || isTable(name, quoted, after, before, create, query[i:])) {
+			this = name
+			named[strings.ToLower(name)] = true
+			if quoted {
+				token = token[:1] + prefix + token[1:]
+			} else {
+				token = prefix + token
+			}
+		}
+		b.WriteString(token)
+		if token == "(" {
+			before = table
+		}
+		table = this
+		lower := strings.ToLower(token)
+		switch {
+		case this != "" && (after == "from" || after == "join"):
+			list = true
+		case list:
+			// the list goes on through aliases and commas, up to the next clause
+			list = lower == "," || name != "" && (quoted || !clauseWords[lower])
+		}
+		prev2, prev = prev, strings.ToLower(token)
+	}
+	return b.String(), named
+}
+
+// isTable returns true if the word names a table, index or trigger,
+// given the token before it and the rest of the query after it
+func isTable(name string, quoted bool, prev, before string, create bool, rest string) bool {
+	if !quoted && sqlWords[strings.ToLower(name)] {
+		return false
+	}
+	switch {
+	case prev == "from" || prev == "join":
+		// a table valued function, e.g., pragma_table_info(...)
+		return !strings.HasPrefix(strings.TrimLeft(rest, " \t\r\n"), "(")
+	case tableWords[prev]:
+		return true
+	case prev == "on":
+		// the table of an index or trigger
+		return create
+	case prev == "(":
+		// the command column of an FTS5 table
+		return before != "" && before == name
+	}
+	return false
+}
+
+// isWordByte returns true if the byte is part of a word
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// namespaceAll returns the queries in the namespace of the prefix
+func namespaceAll(prefix string, queries []string) []string {
+	if prefix == "" {
+		return queries
+	}
+	namespaced := make([]string, len(queries))
+	for i, query := range queries {
+		namespaced[i] = namespaceSQL(prefix, query)
+	}
+	return namespaced
+}
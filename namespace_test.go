@@ -0,0 +1,96 @@
+package rqlobj
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamespaceSQL(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{
+			"create table if not exists hosts (\n    id integer not null primary key,\n    site_id integer references sites(id) on delete cascade on update cascade,\n    tags text\n);",
+			"create table if not exists ns_hosts (\n    id integer not null primary key,\n    site_id integer references ns_sites(id) on delete cascade on update cascade,\n    tags text\n);",
+		},
+		{
+			"create unique index if not exists idx_hosts_name on hosts (name)",
+			"create unique index if not exists ns_idx_hosts_name on ns_hosts (name)",
+		},
+		{
+			"create virtual table if not exists hosts_fts using fts5(name, content='hosts', content_rowid='id');",
+			"create virtual table if not exists ns_hosts_fts using fts5(name, content='ns_hosts', content_rowid='id');",
+		},
+		{
+			"create trigger if not exists hosts_fts_delete after delete on hosts begin\n  insert into hosts_fts(hosts_fts, rowid, name) values ('delete', old.id, old.name);\nend;",
+			"create trigger if not exists ns_hosts_fts_delete after delete on ns_hosts begin\n  insert into ns_hosts_fts(ns_hosts_fts, rowid, name) values ('delete', old.id, old.name);\nend;",
+		},
+		{
+			"create trigger if not exists hosts_changes_update after update on hosts begin\n  insert into hosts_changes (op, keys, object) values ('update', json_array(new.id), json_object('id', new.id));\nend;",
+			"create trigger if not exists ns_hosts_changes_update after update on ns_hosts begin\n  insert into ns_hosts_changes (op, keys, object) values ('update', json_array(new.id), json_object('id', new.id));\nend;",
+		},
+		{
+			"select id,tags from hosts where id in (select host_id from host_tags) and name = 'from sites' order by name;",
+			"select id,tags from ns_hosts where id in (select host_id from ns_host_tags) and name = 'from sites' order by name;",
+		},
+		{
+			`select id from "hosts" h join sites s on s.id = h.site_id`,
+			`select id from "ns_hosts" h join ns_sites s on s.id = h.site_id`,
+		},
+		{
+			"select name, type from pragma_table_info('hosts') ",
+			"select name, type from pragma_table_info('hosts') ",
+		},
+		{
+			"update hosts set name='it''s' where id=1",
+			"update ns_hosts set name='it''s' where id=1",
+		},
+		{
+			"select hosts.id, sites.name, s.id from hosts join sites on sites.id = hosts.site_id join sites s on s.id = sites.parent where hosts.name = 'hosts.name'",
+			"select ns_hosts.id, ns_sites.name, s.id from ns_hosts join ns_sites on ns_sites.id = ns_hosts.site_id join ns_sites s on s.id = ns_sites.parent where ns_hosts.name = 'hosts.name'",
+		},
+		{
+			"select h.id, sites.name from hosts h, sites, regions as r where h.site_id = sites.id and r.id, 'x' = 'x'",
+			"select h.id, ns_sites.name from ns_hosts h, ns_sites, ns_regions as r where h.site_id = ns_sites.id and r.id, 'x' = 'x'",
+		},
+		{
+			"select a, b from hosts join sites, regions where regions.id = 1 order by a, b",
+			"select a, b from ns_hosts join ns_sites, ns_regions where ns_regions.id = 1 order by a, b",
+		},
+		{
+			"select id from hosts, json_each(hosts.tags) where 1 group by id, name",
+			"select id from ns_hosts, json_each(ns_hosts.tags) where 1 group by id, name",
+		},
+		{
+			`select "hosts".id, tags.id from hosts`,
+			`select "ns_hosts".id, tags.id from ns_hosts`,
+		},
+	}
+	for _, test := range tests {
+		if got := namespaceSQL("ns_", test.query); got != test.want {
+			t.Errorf("got  %s\nwant %s", got, test.want)
+		}
+		if got := namespaceSQL("", test.query); got != test.query {
+			t.Errorf("changed without a namespace: %s", got)
+		}
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	var db RDB
+	ns := db.WithNamespace("billing")
+	if got := ns.Table(&testStruct{}); got != "billing_"+tableName {
+		t.Errorf("bad namespaced table: %s", got)
+	}
+	if got := db.Table(&testStruct{}); got != tableName {
+		t.Errorf("the original db is namespaced: %s", got)
+	}
+	if got := ns.WithNamespace("").Table(&testStruct{}); got != tableName {
+		t.Errorf("the namespace was not removed: %s", got)
+	}
+	// a missing table is created in the namespace
+	queries := compareTable(ns.prefix, &testStruct{}, nil, nil).Statements()
+	if len(queries) == 0 || !strings.Contains(queries[0], "create table if not exists billing_"+tableName+" (") {
+		t.Errorf("bad create statements: %v", queries)
+	}
+}
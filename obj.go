@@ -2,10 +2,14 @@
 package rqlobj
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -25,14 +29,40 @@ var (
 	// ErrNotFound is returned when query returns no rows
 	ErrNotFound = errors.New("not found")
 
+	// ErrSchemaCycle is returned when tables reference each other in a loop
+	ErrSchemaCycle = errors.New("foreign key cycle")
+
+	// ErrUnknownColumn is returned when a query result has a column the object lacks
+	ErrUnknownColumn = errors.New("unknown column")
+
+	// ErrNoLeader is returned when the cluster has no leader
+	ErrNoLeader = errors.New("cluster has no leader")
+
+	// ErrNoTenant is returned for objects scoped to tenants when the db is not
+	ErrNoTenant = errors.New("tenant is not set")
+
+	// ErrTenantMismatch is returned for objects belonging to another tenant
+	ErrTenantMismatch = errors.New("object belongs to another tenant")
+
+	// ErrDuplicateKey is returned when adding an object whose key is taken
+	ErrDuplicateKey = errors.New("key already exists")
+
 	singleQuote = regexp.MustCompile("'")
 )
 
 // RDB is a database handler that works with DBOject variables
 type RDB struct {
-	dbs   *gorqlite.Connection
-	debug bool
-	_log  *log.Logger
+	debug  bool
+	_log   *log.Logger
+	trace  *log.Logger  // logs the requests made, if set
+	base   *url.URL     // the url of the node connected to
+	client *http.Client // the client making the requests
+	level  string       // the read consistency level, if not the default
+	cache  *cache       // objects loaded by key, if caching
+	prefix string       // prefix of table names, if namespaced
+	tenant interface{}  // tenant of the objects, if scoped
+	slow   *slowLog     // logs slow statements, if set
+	leader *leaderCache // the api host of the leader, once learned
 }
 
 // Debug sets database debugging on/off
@@ -47,7 +77,10 @@ func (db RDB) Write(queries ...string) ([]gorqlite.WriteResult, error) {
 			db.debugf("Write: %s\n", query)
 		}
 	}
-	return db.dbs.Write(queries)
+	start := time.Now()
+	results, err := db.statements("/db/execute", queries, true)
+	db.logSlow(start, queries...)
+	return writeResults(results), err
 }
 
 // SetLogger sets the logger for the db
@@ -124,20 +157,62 @@ func formatted(item interface{}) string {
 		// escape any "'" by repeating them
 		return "'" + singleQuote.ReplaceAllString(item, "''") + "'"
 	case []byte:
-		return "'" + singleQuote.ReplaceAllString(string(item), "''") + "'"
+		// blobs are sent as binary
+		return "X'" + hex.EncodeToString(item) + "'"
 	case time.Time:
 		if item.IsZero() {
 			return "null"
 		}
 		return fmt.Sprint(item.Unix())
+	case bool:
+		if item {
+			return "1"
+		}
+		return "0"
 	case int:
 		return strconv.Itoa(item)
+	case int8:
+		return strconv.FormatInt(int64(item), 10)
+	case int16:
+		return strconv.FormatInt(int64(item), 10)
+	case int32:
+		return strconv.FormatInt(int64(item), 10)
 	case int64:
-		return strconv.Itoa(int(item))
-	case uint64:
-		return strconv.FormatUint(item, 10)
+		return strconv.FormatInt(item, 10)
+	case uint:
+		return strconv.FormatUint(uint64(item), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(item), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(item), 10)
 	case uint32:
 		return strconv.FormatUint(uint64(item), 10)
+	case uint64:
+		return strconv.FormatUint(item, 10)
+	case float32:
+		return strconv.FormatFloat(float64(item), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(item, 'g', -1, 64)
+	case JSONField:
+		text, err := item.encode()
+		if err != nil || text == "null" {
+			return "null"
+		}
+		return formatted(text)
+	}
+	// named types are formatted by their underlying kind
+	v := reflect.ValueOf(item)
+	switch v.Kind() {
+	case reflect.Bool:
+		return formatted(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return formatted(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return formatted(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return formatted(v.Float())
+	case reflect.String:
+		return formatted(v.String())
 	}
 	return fmt.Sprintf("'%s'", item)
 }
@@ -172,71 +247,42 @@ func insertFields(o DBObject) string {
 	return strings.Join(list, ",")
 }
 
-//func setParams(fields []string, values []interface{}) string {
-func setParams(o DBObject) string {
-	fields := o.InsertFields()
-	values := o.InsertValues()
-	var buf strings.Builder
-	for i, field := range fields {
-		fmt.Printf("FIELD (%T): %v\n", field, field)
-		//buf.WriteString(field)
-		buf.WriteString("=")
-		switch val := values[i].(type) {
-		case string:
-			buf.WriteByte('\'')
-			buf.WriteString(val)
-			buf.WriteByte('\'')
-		case int:
-			buf.WriteString(strconv.Itoa(val))
-		case int64:
-			buf.WriteString(strconv.Itoa(int(val)))
-		case time.Time:
-			buf.WriteString(strconv.Itoa(int(val.Unix())))
-		default:
-			panic(fmt.Sprintf("unknown type: %T (%v)\n", val, val))
-
-		}
-	}
-	return buf.String()
-}
-
-func replaceQuery(o DBObject) string {
-	p := fieldList(o.InsertValues())
-	return fmt.Sprintf("replace into %s (%s) values(%s)", o.TableName(), insertFields(o), p)
-}
-
-func updateQuery(o DBObject) string {
-	fmt.Println("fix the 'set' part of the update statement")
-	if id, ok := o.Primary(); ok {
-		return fmt.Sprintf("update %s set %s where %s=%d", o.TableName(), setParams(o), o.KeyFields()[0], id)
+// updateQuery returns the statement setting the columns of the object's row,
+// in the table named with the prefix
+func updateQuery(prefix string, o DBObject, columns []string) (string, error) {
+	keys := o.KeyFields()
+	if len(keys) == 0 {
+		return "", ErrNoKeyField
 	}
-	values := o.KeyValues()
-	var where strings.Builder
-	for i, key := range o.KeyFields() {
-		if i > 0 {
-			where.WriteString(" and ")
-		}
-		where.WriteString(key)
-		where.WriteString("=")
-		switch val := values[i].(type) {
-		case string:
-			where.WriteByte('\'')
-			where.WriteString(val)
-			where.WriteByte('\'')
-		default:
-			where.WriteString(fmt.Sprint(val))
+	values := columnValues(o)
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		if err := jsonError(values[column]); err != nil {
+			return "", fmt.Errorf("%s: %w", column, err)
 		}
+		set[i] = column + "=" + formatted(values[column])
 	}
-
-	return fmt.Sprintf("update %s set %s where %s", o.TableName(), setParams(o), where.String())
+	where := make([]string, len(keys))
+	for i, value := range o.KeyValues() {
+		where[i] = keys[i] + "=" + formatted(value)
+	}
+	const text = "update %s set %s where %s"
+	return fmt.Sprintf(text, prefix+o.TableName(), join(set), strings.Join(where, " and ")), nil
 }
 
-func deleteQuery(o DBObject, key int64) string {
+func deleteQuery(prefix string, o DBObject, key int64, scope string) string {
 	// TODO: need to support non-int, multi-column keys
-	if key == 0 {
-		return fmt.Sprintf("delete from %s;", o.TableName())
+	var where []string
+	if key != 0 {
+		where = append(where, fmt.Sprintf("%s=%d", o.KeyFields()[0], key))
 	}
-	return fmt.Sprintf("delete from %s where %s=%v;", o.TableName(), o.KeyFields(), key)
+	if scope != "" {
+		where = append(where, scope)
+	}
+	if len(where) == 0 {
+		return fmt.Sprintf("delete from %s;", prefix+o.TableName())
+	}
+	return fmt.Sprintf("delete from %s where %s;", prefix+o.TableName(), strings.Join(where, " and "))
 }
 
 func within(s string, list []string) bool {
@@ -252,49 +298,142 @@ func join(list []string) string {
 	return strings.Join(list, ",")
 }
 
-func upsertQuery(o DBObject) string {
-	values := o.InsertValues()
-	fields := strings.Split(o.InsertFields(), ",")
-	// do not include fields with unset time -- it's effectively null
-	for i := 0; i < len(fields); i++ {
-		//fmt.Printf("%d/%d %T:%v\n", i+1, len(values), values[i], values[i])
-		p := fields[i]
-		if within(p, o.KeyFields()) {
-			// nada, just to skip the continue
-		} else if t, ok := values[i].(time.Time); ok && !t.IsZero() {
-			// nada, just to skip the continue
-		} else {
+// Defaulter is implemented by objects having insert fields with
+// default values, as generated by dbgen
+type Defaulter interface {
+	// DefaultFields returns the insert fields that have default values
+	DefaultFields() []string
+}
+
+// isZero returns true if the value is the zero value of its type
+func isZero(v interface{}) bool {
+	if j, ok := v.(JSONField); ok {
+		v = j.V
+	}
+	if v == nil {
+		return true
+	}
+	return reflect.ValueOf(v).IsZero()
+}
+
+// upsertQuery returns the statement adding the object, unless its keys are
+// taken, to the table named with the prefix. Keys are written unless zero,
+// which leaves rowids to the database, and are taken from the key values
+// of objects that do not list them in their insert fields
+func upsertQuery(prefix string, o DBObject) (string, error) {
+	var defaults []string
+	if d, ok := o.(Defaulter); ok {
+		defaults = d.DefaultFields()
+	}
+	keys := o.KeyFields()
+	inserted := strings.Split(o.InsertFields(), ",")
+	all := o.InsertValues()
+	values := make([]interface{}, 0, len(all)+len(keys))
+	fields := make([]string, 0, len(all)+len(keys))
+	for i, value := range o.KeyValues() {
+		if i < len(keys) && !within(keys[i], inserted) && !isZero(value) {
+			fields = append(fields, keys[i])
+			values = append(values, value)
+		}
+	}
+	for i, p := range inserted {
+		if within(p, keys) && isZero(all[i]) {
+			continue
+		}
+		// do not include fields with unset time -- it's effectively null
+		if t, ok := all[i].(time.Time); ok && t.IsZero() {
+			continue
+		}
+		// nor unset fields that have defaults, so the default applies
+		if within(p, defaults) && isZero(all[i]) {
 			continue
 		}
-		i--
-		fields = append(fields[:i], fields[i+1:]...)
-		values = append(values[:i], values[i+1:]...)
+		if err := jsonError(all[i]); err != nil {
+			return "", fmt.Errorf("%s: %w", p, err)
+		}
+		fields = append(fields, p)
+		values = append(values, all[i])
 	}
 	const text = "INSERT into %s (%s) values(%s) on conflict(%s) do nothing"
-	return fmt.Sprintf(text, o.TableName(), join(fields), fieldList(values...), join(o.KeyFields()))
+	return fmt.Sprintf(text, prefix+o.TableName(), join(fields), fieldList(values...), join(keys)), nil
 }
 
 // Add new object to datastore
 func (db RDB) Add(o DBObject) error {
-	query := upsertQuery(o)
+	if err := db.setTenant(o); err != nil {
+		return err
+	}
+	query, err := upsertQuery(db.prefix, o)
+	if err != nil {
+		return err
+	}
 	results, err := db.Write(query)
+	db.invalidate(o)
 	if err != nil {
 		for _, result := range results {
-			fmt.Println("RES ERR:", result.Err)
+			db.debugf("add error: %+v\n", result.Err)
 		}
 		return err
 	}
 	if len(results) > 0 {
+		// the row with the same keys is left as is
+		if results[0].RowsAffected == 0 {
+			return db.conflict(o)
+		}
 		// If not a primary object this is a NOP
 		o.SetPrimary(results[0].LastInsertID)
 	}
+	snapshot(o)
 	return nil
 }
 
-// Update saves a modified object in the datastore
+// conflict returns the error for an object whose keys are taken, which
+// is ErrTenantMismatch when the row taking them belongs to another tenant
+func (db RDB) conflict(o DBObject) error {
+	scope, err := db.scope(o)
+	if err != nil || scope == "" {
+		return ErrDuplicateKey
+	}
+	keys := o.KeyFields()
+	where := make([]string, len(keys), len(keys)+1)
+	for i, value := range o.KeyValues() {
+		where[i] = keys[i] + "=" + formatted(value)
+	}
+	where = append(where, scope)
+	const text = "select count(*) from %s where %s"
+	query := fmt.Sprintf(text, db.prefix+o.TableName(), strings.Join(where, " and "))
+	var count int64
+	if err := db.get([]interface{}{&count}, query); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrTenantMismatch
+	}
+	return ErrDuplicateKey
+}
+
+// Update saves a modified object in the datastore. Objects that
+// track their changes only have the changed columns written, if any
 func (db RDB) Update(o DBObject) error {
-	query := upsertQuery(o)
+	if err := db.checkTenant(o); err != nil {
+		return err
+	}
+	scope, _ := db.scope(o)
+	columns := updateColumns(o)
+	if t, ok := o.(Tracker); ok {
+		if columns = t.Changed(); len(columns) == 0 {
+			return nil
+		}
+	}
+	query, err := updateQuery(db.prefix, o, columns)
+	if err != nil {
+		return err
+	}
+	if scope != "" {
+		query += " and " + scope
+	}
 	results, err := db.Write(query)
+	db.invalidate(o)
 	for _, result := range results {
 		if result.Err != nil {
 			// assuming that if there's an error here,
@@ -305,9 +444,11 @@ func (db RDB) Update(o DBObject) error {
 	if err != nil {
 		return err
 	}
-	if len(results) > 0 {
-		o.SetPrimary(results[0].LastInsertID)
+	// the row of another tenant is left as is
+	if scope != "" && len(results) > 0 && results[0].RowsAffected == 0 {
+		return ErrNotFound
 	}
+	snapshot(o)
 	return nil
 }
 
@@ -321,9 +462,14 @@ func (db RDB) Delete(o DBObject) error {
 
 // DeleteByID object from datastore by id
 func (db RDB) DeleteByID(o DBObject, id int64) error {
-	query := deleteQuery(o, id)
+	scope, err := db.scope(o)
+	if err != nil {
+		return err
+	}
+	query := deleteQuery(db.prefix, o, id, scope)
 	db.debugf(query)
 	results, err := db.Write(query)
+	db.invalidate(o)
 	if err != nil {
 		return err
 	}
@@ -342,30 +488,78 @@ func (db RDB) DeleteAll(o DBObject) error {
 
 // Load loads an object matching the given keys
 func (db RDB) Load(o DBObject, keys map[string]interface{}) error {
-	where := make([]string, 0, len(keys))
+	scope, err := db.scope(o)
+	if err != nil {
+		return err
+	}
+	where := make([]string, 0, len(keys)+1)
 	for k, v := range keys {
-		where = append(where, fmt.Sprintf("%s=%v", k, v))
+		where = append(where, fmt.Sprintf("%s=%s", k, formatted(v)))
+	}
+	if scope != "" {
+		where = append(where, scope)
 	}
 	const text = "select %s from %s where %s"
-	query := fmt.Sprintf(text, o.SelectFields(), o.TableName(), strings.Join(where, " and "))
-	return db.get(o.Receivers(), query)
+	query := fmt.Sprintf(text, o.SelectFields(), db.Table(o), strings.Join(where, " and "))
+	columns := make([]string, 0, len(keys))
+	values := make([]interface{}, 0, len(keys))
+	for k, v := range keys {
+		columns = append(columns, k)
+		values = append(values, v)
+	}
+	return db.load(o, query, columns, values)
 }
 
 // LoadBy loads an  object matching the given key/value
 func (db RDB) LoadBy(o DBObject, key string, value interface{}) error {
+	scope, err := db.scope(o)
+	if err != nil {
+		return err
+	}
 	var text, query string
 	switch value := value.(type) {
 	case string:
 		text = "select %s from %s where %s='%s'"
-		query = fmt.Sprintf(text, o.SelectFields(), o.TableName(), key, value)
+		query = fmt.Sprintf(text, o.SelectFields(), db.Table(o), key, value)
 	case int, int64, uint, uint64:
 		text = "select %s from %s where %s=%d"
-		query = fmt.Sprintf(text, o.SelectFields(), o.TableName(), key, value)
+		query = fmt.Sprintf(text, o.SelectFields(), db.Table(o), key, value)
 	default:
 		text = "select %s from %s where %s=%v"
-		query = fmt.Sprintf(text, o.SelectFields(), o.TableName(), key, value)
+		query = fmt.Sprintf(text, o.SelectFields(), db.Table(o), key, value)
+	}
+	if scope != "" {
+		query += " and " + scope
+	}
+	return db.load(o, query, []string{key}, []interface{}{value})
+}
+
+// load loads the object with the query for the column values, from the cache
+// if caching and they are its keys, and records its state if tracked
+func (db RDB) load(o DBObject, query string, columns []string, values []interface{}) error {
+	key, cached := cacheKey(o, columns, values)
+	cached = cached && db.cache != nil
+	if scope, _ := db.scope(o); scope != "" {
+		// tenants share the cache, but not their objects
+		key = scope + "\x00" + key
+	}
+	var generation uint64
+	if cached {
+		if db.cache.get(db.Table(o), o, key) {
+			snapshot(o)
+			return nil
+		}
+		// writes made while reading leave the object out of the cache
+		generation = db.cache.generation(db.Table(o))
+	}
+	if err := db.get(o.Receivers(), query); err != nil {
+		return err
+	}
+	if cached {
+		db.cache.put(db.Table(o), o, key, generation)
 	}
-	return db.get(o.Receivers(), query)
+	snapshot(o)
+	return nil
 }
 
 // LoadByID loads an object based on a given int64 primary ID
@@ -431,18 +625,21 @@ func (db RDB) List(list DBList) error {
 
 // ListQuery updates a list of objects
 func (db RDB) ListQuery(list DBList, where string) error {
-	query := list.SQLGet(where)
-	results, err := db.dbs.Query([]string{query})
+	query, err := db.listQuery(list, where)
+	if err != nil {
+		return err
+	}
+	results, err := db.query(query)
 	if err != nil {
 		for _, res := range results {
-			fmt.Println("LQ ERR:", res.Err)
+			db.debugf("list error: %+v\n", res.Err)
 		}
 		return err
 	}
 	for _, result := range results {
 		for result.Next() {
 			fn := func(ptrs ...interface{}) error {
-				if err := result.Scan(ptrs...); err != nil {
+				if err := scan(&result, ptrs...); err != nil {
 					return fmt.Errorf("%w: with ptrs: %s", err, typeinfo(ptrs...))
 				}
 				return err
@@ -453,36 +650,143 @@ func (db RDB) ListQuery(list DBList, where string) error {
 			}
 		}
 	}
+	snapshot(list)
 	return nil
 }
 
+// listQuery returns the query for the list's objects meeting the criteria,
+// in the db's namespace and restricted to its tenant
+func (db RDB) listQuery(list DBList, where string) (string, error) {
+	scope, err := db.listScope(list)
+	if err != nil {
+		return "", err
+	}
+	return namespaceSQL(db.prefix, list.SQLGet(scopeWhere(list, where, scope))), nil
+}
+
 // get is the low level db wrapper
 func (db RDB) get(receivers []interface{}, query string) error {
 	db.debugf("get query:%s\n", query)
-	result, err := db.dbs.QueryOne(query)
+	result, err := db.queryOne(query)
 	if err != nil {
 		db.debugf("error on get query: %q :: %v\n", query, err)
 		return err
 	}
 	if result.Next() {
-		return result.Scan(receivers...)
+		return scan(&result, receivers...)
 	}
 	return ErrNotFound
 }
 
-// NewRqlite returns a RDB connected to a rqlite cluster
-func NewRqlite(host string, logger, trace io.Writer) (RDB, error) {
-	conn, err := gorqlite.Open(host)
-	if logger == nil {
-		logger = ioutil.Discard
+// scan wraps the Scan of the current row, standing in
+// for receivers that it does not handle natively
+func scan(result *resultSet, dest ...interface{}) error {
+	ptrs := make([]interface{}, len(dest))
+	types := result.Types()
+	var convert []func() error
+	for i, d := range dest {
+		var ctype string
+		if i < len(types) {
+			ctype = types[i]
+		}
+		var fn func() error
+		ptrs[i], fn = receiver(d, ctype)
+		if fn != nil {
+			convert = append(convert, fn)
+		}
+	}
+	if err := result.Scan(ptrs...); err != nil {
+		return err
+	}
+	for _, fn := range convert {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiver returns the pointer to give to Scan in place of dest,
+// and if they differ, the function to apply the scanned value to dest
+func receiver(dest interface{}, ctype string) (interface{}, func() error) {
+	switch d := dest.(type) {
+	case *time.Time, *int, *int64, *float64, *string:
+		return dest, nil
+	case JSONField:
+		var text string
+		return &text, func() error {
+			return d.decode(text)
+		}
+	case *[]byte:
+		// rqlite returns blobs base64 encoded
+		var text string
+		return &text, func() error {
+			if !strings.EqualFold(ctype, "blob") {
+				*d = []byte(text)
+				return nil
+			}
+			b, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return fmt.Errorf("decoding blob: %w", err)
+			}
+			*d = b
+			return nil
+		}
 	}
-	dbu := RDB{_log: log.New(logger, "", 0)}
-	if err == nil {
-		if trace != nil {
-			gorqlite.TraceOn(trace)
-			dbu.debug = true
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return dest, nil
+	}
+	elem := v.Elem()
+	switch elem.Kind() {
+	case reflect.Bool:
+		var i int64
+		return &i, func() error {
+			elem.SetBool(i != 0)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		return &i, func() error {
+			if elem.OverflowInt(i) {
+				return fmt.Errorf("value %d overflows %s", i, elem.Type())
+			}
+			elem.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var i int64
+		return &i, func() error {
+			if i < 0 || elem.OverflowUint(uint64(i)) {
+				return fmt.Errorf("value %d overflows %s", i, elem.Type())
+			}
+			elem.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		return &f, func() error {
+			elem.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		var text string
+		return &text, func() error {
+			elem.SetString(text)
+			return nil
 		}
-		dbu.dbs = &conn
 	}
-	return dbu, err
+	return dest, nil
+}
+
+// NewRqlite returns a RDB connected to a rqlite cluster
+func NewRqlite(host string, logger, trace io.Writer) (RDB, error) {
+	return NewRqliteConfig(Config{URL: host, Logger: logger, Trace: trace})
+}
+
+// ForeignKeys returns true if the cluster enforces foreign key constraints
+func (db RDB) ForeignKeys() (bool, error) {
+	var on int64
+	err := db.get([]interface{}{&on}, "pragma foreign_keys")
+	return on != 0, err
 }
@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -29,12 +31,6 @@ type testStruct struct {
 	anint    int
 }
 
-func init() {
-	// *** for now ***
-	// make sure proxy is set up (rqlite is inside docker)
-	os.Setenv("http_proxy", "http://localhost:8888/")
-}
-
 func (s *testStruct) equal(other *testStruct) error {
 	if s.ID != other.ID {
 		return fmt.Errorf("New ID: %d doesn't match orig: %d\n", other.ID, s.ID)
@@ -169,11 +165,18 @@ func structDb(t *testing.T) RDB {
 		fmt.Println("we are verbose")
 		out = os.Stdout
 	}
-	dbs, err := NewRqlite("http://rbox1:4001", out, w)
+	// rqlite is inside docker, reached through the proxy
+	proxy, _ := url.Parse("http://localhost:8888/")
+	dbs, err := NewRqliteConfig(Config{
+		URL:    "http://rbox1:4001",
+		Client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}},
+		Logger: out,
+		Trace:  w,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err = dbs.dbs.Write(canned()); err != nil {
+	if _, err = dbs.Write(canned()...); err != nil {
 		t.Fatal(err)
 	}
 	if testing.Verbose() {
@@ -389,3 +392,165 @@ func TestDelete(t *testing.T) {
 		t.Fatal("expected error but got none")
 	}
 }
+
+func TestFormatted(t *testing.T) {
+	type kind int16
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{"it's", "'it''s'"},
+		{true, "1"},
+		{false, "0"},
+		{int8(-8), "-8"},
+		{int32(32), "32"},
+		{uint16(16), "16"},
+		{uint(7), "7"},
+		{float32(1.5), "1.5"},
+		{float64(-0.25), "-0.25"},
+		{[]byte{0xde, 0xad}, "X'dead'"},
+		{kind(3), "3"},
+		{nil, "null"},
+	}
+	for _, tt := range tests {
+		if got := formatted(tt.in); got != tt.want {
+			t.Errorf("formatted(%T %v): got %s, want %s", tt.in, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	var (
+		b   bool
+		i8  int8
+		u16 uint16
+		f32 float32
+		raw []byte
+	)
+	set := func(dest interface{}, ctype string, value interface{}) error {
+		ptr, fn := receiver(dest, ctype)
+		if fn == nil {
+			t.Fatalf("no conversion for %T", dest)
+		}
+		switch ptr := ptr.(type) {
+		case *int64:
+			*ptr = value.(int64)
+		case *float64:
+			*ptr = value.(float64)
+		case *string:
+			*ptr = value.(string)
+		}
+		return fn()
+	}
+	if err := set(&b, "integer", int64(1)); err != nil || !b {
+		t.Errorf("bool: %v %v", b, err)
+	}
+	if err := set(&i8, "integer", int64(-8)); err != nil || i8 != -8 {
+		t.Errorf("int8: %v %v", i8, err)
+	}
+	if err := set(&i8, "integer", int64(1000)); err == nil {
+		t.Errorf("int8: expected overflow error")
+	}
+	if err := set(&u16, "integer", int64(16)); err != nil || u16 != 16 {
+		t.Errorf("uint16: %v %v", u16, err)
+	}
+	if err := set(&f32, "real", 1.5); err != nil || f32 != 1.5 {
+		t.Errorf("float32: %v %v", f32, err)
+	}
+	if err := set(&raw, "blob", "3q0="); err != nil || string(raw) != "\xde\xad" {
+		t.Errorf("blob: %x %v", raw, err)
+	}
+	if ptr, fn := receiver(new(int64), "integer"); fn != nil || ptr == nil {
+		t.Errorf("int64 should be scanned natively")
+	}
+}
+
+// defaultStruct has a database default for its kind
+type defaultStruct struct {
+	testStruct
+}
+
+func (s *defaultStruct) DefaultFields() []string {
+	return []string{"kind"}
+}
+
+func TestUpsertDefaults(t *testing.T) {
+	s := &defaultStruct{testStruct{Name: "def", Data: "x"}}
+	const omitted = "INSERT into test_structs (name,data) values('def', 'x') on conflict(id) do nothing"
+	if got, _ := upsertQuery("", s); got != omitted {
+		t.Errorf("got %s\nwant %s", got, omitted)
+	}
+	s.Kind = 3
+	const kept = "INSERT into test_structs (name,kind,data) values('def', 3, 'x') on conflict(id) do nothing"
+	if got, _ := upsertQuery("", s); got != kept {
+		t.Errorf("got %s\nwant %s", got, kept)
+	}
+}
+
+// memberStruct has a composite key, listed first in its insert fields as by dbgen
+type memberStruct struct {
+	testStruct
+}
+
+func (s *memberStruct) TableName() string {
+	return "members"
+}
+
+func (s *memberStruct) KeyFields() []string {
+	return []string{"id", "kind"}
+}
+
+func (s *memberStruct) KeyValues() []interface{} {
+	return []interface{}{s.ID, s.Kind}
+}
+
+func (s *memberStruct) InsertFields() string {
+	return "id,kind,name,data"
+}
+
+func (s *memberStruct) InsertValues() []interface{} {
+	return []interface{}{s.ID, s.Kind, s.Name, s.Data}
+}
+
+func TestUpsertKeys(t *testing.T) {
+	s := &testStruct{ID: 5, Name: "five"}
+	const keyed = "INSERT into test_structs (id,name,kind,data) values(5, 'five', 0, '') on conflict(id) do nothing"
+	if got, _ := upsertQuery("", s); got != keyed {
+		t.Errorf("got %s\nwant %s", got, keyed)
+	}
+
+	m := &memberStruct{testStruct{ID: 2, Kind: 3, Name: "member", Data: "x"}}
+	const composite = "INSERT into members (id,kind,name,data) values(2, 3, 'member', 'x') on conflict(id,kind) do nothing"
+	query, _ := upsertQuery("", m)
+	if query != composite {
+		t.Errorf("got %s\nwant %s", query, composite)
+	}
+
+	local, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	const create = `create table members (
+  id integer not null,
+  kind integer not null,
+  name text,
+  data text,
+  primary key (id, kind)
+) without rowid`
+	if _, err := local.Exec(create); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var id, kind, count int
+	if err := local.QueryRow("select id, kind, count(*) from members").Scan(&id, &kind, &count); err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 || kind != 3 || count != 1 {
+		t.Errorf("got member %d/%d, %d rows", id, kind, count)
+	}
+}
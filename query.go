@@ -0,0 +1,304 @@
+package rqlobj
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ColumnMapper is implemented by objects that map their select fields
+// to their receivers by column name, as generated by dbgen
+type ColumnMapper interface {
+	ColumnReceivers() map[string]interface{}
+}
+
+var dbObjectType = reflect.TypeOf((*DBObject)(nil)).Elem()
+
+// QueryInto runs the query and scans the results into dst, matching the
+// result columns to the object's fields by name rather than by position,
+// so the query may select the columns in any order, or only some of them.
+// dst is either an object, which is loaded from the first row, or a pointer
+// to a slice of objects, which the rows are appended to.
+// Each ? in the query is replaced by the corresponding arg
+func (db RDB) QueryInto(dst interface{}, query string, args ...interface{}) error {
+	o, single := dst.(DBObject)
+	var create func() DBObject
+	var add func(DBObject)
+	if !single {
+		var err error
+		if create, add, err = appender(dst); err != nil {
+			return err
+		}
+		o = create()
+	}
+	result, err := db.queryResult(query, args...)
+	if err != nil {
+		return err
+	}
+	// unknown columns are reported even when there are no rows
+	columns := result.Columns()
+	dest, err := columnReceivers(o, columns)
+	if err != nil {
+		return err
+	}
+	if single {
+		if !result.Next() {
+			return ErrNotFound
+		}
+		if err := scan(&result, dest...); err != nil {
+			return err
+		}
+		snapshot(o)
+		return nil
+	}
+	for result.Next() {
+		o := create()
+		dest, err := columnReceivers(o, columns)
+		if err != nil {
+			return err
+		}
+		if err := scan(&result, dest...); err != nil {
+			return err
+		}
+		snapshot(o)
+		add(o)
+	}
+	return nil
+}
+
+// Rows is the result of a query, with each value converted according
+// to the type of its column as reported by rqlite:
+// integers are int64, reals are float64, text is a string,
+// blobs are []byte, datetime columns are time.Time and nulls are nil.
+// Values of columns without a type, such as expressions, are int64
+// if they are integers, float64 if other numbers, otherwise as returned
+type Rows struct {
+	Columns []string
+	Types   []string
+	Values  [][]interface{}
+}
+
+// Maps returns the rows as maps of column name to value
+func (r *Rows) Maps() []map[string]interface{} {
+	maps := make([]map[string]interface{}, len(r.Values))
+	for i, row := range r.Values {
+		m := make(map[string]interface{}, len(r.Columns))
+		for j, column := range r.Columns {
+			m[column] = row[j]
+		}
+		maps[i] = m
+	}
+	return maps
+}
+
+// QueryRows runs the query and returns its columns, their types and the rows.
+// Each ? in the query is replaced by the corresponding arg
+func (db RDB) QueryRows(query string, args ...interface{}) (*Rows, error) {
+	result, err := db.queryResult(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{
+		Columns: result.Columns(),
+		Types:   append([]string(nil), result.Types()...),
+	}
+	for result.Next() {
+		raw := result.Row()
+		row := make([]interface{}, len(rows.Columns))
+		for i, column := range rows.Columns {
+			var err error
+			if row[i], err = convert(raw[i], rows.Types[i]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column, err)
+			}
+		}
+		rows.Values = append(rows.Values, row)
+	}
+	return rows, nil
+}
+
+// QueryMaps runs the query and returns each row as a map of column name to value,
+// converted as for QueryRows. Each ? in the query is replaced by the corresponding arg
+func (db RDB) QueryMaps(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.QueryRows(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows.Maps(), nil
+}
+
+// convert returns the value, as decoded from json,
+// as the go type matching the declared column type
+func convert(v interface{}, ctype string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	t := strings.ToLower(ctype)
+	switch {
+	case strings.Contains(t, "date"), strings.Contains(t, "time"):
+		return toTime(v)
+	case t == "blob":
+		// rqlite returns blobs base64 encoded
+		if text, ok := v.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("decoding blob: %w", err)
+			}
+			return b, nil
+		}
+	case t != "" && affinity(t) == "real":
+		if n, ok := v.(json.Number); ok {
+			return n.Float64()
+		}
+		return v, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		return numberValue(n)
+	}
+	// whole numbers are meant as integers
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f), nil
+	}
+	return v, nil
+}
+
+// timeLayouts are the text formats of times stored by sqlite's date functions
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// toTime returns the time of a unix timestamp or of its text
+func toTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case json.Number:
+		seconds, err := scanInt(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time: %v", v)
+		}
+		return time.Unix(seconds, 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %v", v)
+}
+
+// appender returns functions to create the objects of a pointer
+// to a slice of objects, and to append them to the slice
+func appender(list interface{}) (func() DBObject, func(DBObject), error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return nil, nil, fmt.Errorf("%T is not an object or a pointer to a slice of objects", list)
+	}
+	slice := v.Elem()
+	elem := slice.Type().Elem()
+	base := elem
+	if elem.Kind() == reflect.Ptr {
+		base = elem.Elem()
+	}
+	if !reflect.PtrTo(base).Implements(dbObjectType) {
+		return nil, nil, fmt.Errorf("%s is not a DBObject", reflect.PtrTo(base))
+	}
+	create := func() DBObject {
+		return reflect.New(base).Interface().(DBObject)
+	}
+	add := func(o DBObject) {
+		item := reflect.ValueOf(o)
+		if elem.Kind() != reflect.Ptr {
+			item = item.Elem()
+		}
+		slice.Set(reflect.Append(slice, item))
+	}
+	return create, add, nil
+}
+
+// columnReceivers returns the object's receivers for the columns, in order
+func columnReceivers(o DBObject, columns []string) ([]interface{}, error) {
+	var byName map[string]interface{}
+	if mapper, ok := o.(ColumnMapper); ok {
+		byName = mapper.ColumnReceivers()
+	} else {
+		receivers := o.Receivers()
+		fields := strings.Split(o.SelectFields(), ",")
+		byName = make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			if i < len(receivers) {
+				byName[strings.TrimSpace(field)] = receivers[i]
+			}
+		}
+	}
+	lower := make(map[string]interface{}, len(byName))
+	for name, r := range byName {
+		lower[strings.ToLower(name)] = r
+	}
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		r, ok := byName[column]
+		if !ok {
+			// sqlite column names are case insensitive
+			r, ok = lower[strings.ToLower(column)]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %q has no field for %T", ErrUnknownColumn, column, o)
+		}
+		dest[i] = r
+	}
+	return dest, nil
+}
+
+// bind replaces each ? in the query, outside of quoted text, with its formatted arg
+func bind(query string, args ...interface{}) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+	var b strings.Builder
+	var quote rune
+	n := 0
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			if n >= len(args) {
+				return "", fmt.Errorf("query has more placeholders than the %d args", len(args))
+			}
+			b.WriteString(formatted(args[n]))
+			n++
+			continue
+		}
+		b.WriteRune(c)
+	}
+	if n < len(args) {
+		return "", fmt.Errorf("query has %d placeholders for %d args", n, len(args))
+	}
+	return b.String(), nil
+}
+
+// queryResult runs the query with its args bound
+func (db RDB) queryResult(query string, args ...interface{}) (resultSet, error) {
+	query, err := bind(query, args...)
+	if err != nil {
+		return resultSet{}, err
+	}
+	db.debugf("query: %s\n", query)
+	result, err := db.queryOne(query)
+	if err != nil {
+		db.debugf("error on query: %q :: %v\n", query, err)
+	}
+	return result, err
+}
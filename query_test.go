@@ -0,0 +1,120 @@
+package rqlobj
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{"select * from t where id = ?", []interface{}{3}, "select * from t where id = 3"},
+		{"select * from t where name = ? and kind in (?, ?)", []interface{}{"o'neil", 1, 2}, "select * from t where name = 'o''neil' and kind in (1, 2)"},
+		{"select '?' as q from t where id = ?", []interface{}{nil}, "select '?' as q from t where id = null"},
+		{"select 1", nil, "select 1"},
+	} {
+		got, err := bind(tc.query, tc.args...)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+		} else if got != tc.want {
+			t.Errorf("got %s\nwant %s", got, tc.want)
+		}
+	}
+	if _, err := bind("select ?, ?", 1); err == nil {
+		t.Errorf("expected error for too few args")
+	}
+	if _, err := bind("select ?", 1, 2); err == nil {
+		t.Errorf("expected error for too many args")
+	}
+}
+
+func TestColumnReceivers(t *testing.T) {
+	s := &testStruct{}
+	dest, err := columnReceivers(s, []string{"kind", "ID", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest[0] != &s.Kind || dest[1] != &s.ID || dest[2] != &s.Name {
+		t.Errorf("receivers do not match the columns: %v", dest)
+	}
+	if _, err := columnReceivers(s, []string{"id", "nope"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("expected unknown column error but got %v", err)
+	}
+}
+
+func TestAppender(t *testing.T) {
+	var list []testStruct
+	create, add, err := appender(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := create()
+	o.SetPrimary(5)
+	add(o)
+	if len(list) != 1 || list[0].ID != 5 {
+		t.Errorf("object not appended: %v", list)
+	}
+	var ptrs []*testStruct
+	if _, _, err := appender(&ptrs); err != nil {
+		t.Errorf("pointer list: %v", err)
+	}
+	if _, _, err := appender(list); err == nil {
+		t.Errorf("expected error for a slice not given by pointer")
+	}
+	if _, _, err := appender(&[]string{}); err == nil {
+		t.Errorf("expected error for a list of strings")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	when := time.Date(2019, 9, 11, 19, 54, 37, 0, time.UTC)
+	for _, tc := range []struct {
+		value interface{}
+		ctype string
+		want  interface{}
+	}{
+		{nil, "integer", nil},
+		{float64(42), "integer", int64(42)},
+		{float64(42), "INT", int64(42)},
+		{float64(42), "real", float64(42)},
+		{float64(1.5), "", float64(1.5)},
+		{float64(7), "", int64(7)},
+		{"42", "text", "42"},
+		{"aGk=", "blob", []byte("hi")},
+		{float64(when.Unix()), "datetime", time.Unix(when.Unix(), 0)},
+		{"2019-09-11 19:54:37", "datetime", when},
+		{"2019-09-11T19:54:37Z", "timestamp", when},
+	} {
+		got, err := convert(tc.value, tc.ctype)
+		if err != nil {
+			t.Errorf("%v (%s): %v", tc.value, tc.ctype, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v (%s): got %#v want %#v", tc.value, tc.ctype, got, tc.want)
+		}
+	}
+	if _, err := convert("yesterday", "date"); err == nil {
+		t.Errorf("expected error for an invalid time")
+	}
+	if _, err := convert("not base64!", "blob"); err == nil {
+		t.Errorf("expected error for an invalid blob")
+	}
+}
+
+func TestRowsMaps(t *testing.T) {
+	rows := &Rows{
+		Columns: []string{"id", "name"},
+		Types:   []string{"integer", "text"},
+		Values:  [][]interface{}{{int64(1), "one"}, {int64(2), nil}},
+	}
+	maps := rows.Maps()
+	if len(maps) != 2 || maps[0]["name"] != "one" || maps[1]["id"] != int64(2) || maps[1]["name"] != nil {
+		t.Errorf("bad maps: %v", maps)
+	}
+}
@@ -0,0 +1,98 @@
+package rqlobj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// queueMarker is the statement queued by WaitFor, to learn when the
+// writes queued ahead of it are applied
+const queueMarker = "select 1"
+
+// queueReply is the reply of a queued write
+type queueReply struct {
+	apiResults
+	Sequence int64 `json:"sequence_number"`
+}
+
+// WriteQueued queues the statements for rqlite to write in the background,
+// batched with other queued writes, returning the sequence number of the
+// request. The write is acknowledged before it is applied, so its errors
+// are not reported; WaitFor waits for it to be applied
+func (db RDB) WriteQueued(queries ...string) (int64, error) {
+	if len(queries) == 0 {
+		return 0, nil
+	}
+	return db.queue(queries, false)
+}
+
+// AddQueued adds the object with a queued write, returning the sequence
+// number of the request. Unlike Add, the primary key of the object is not
+// set, as it is not known until the write is applied
+func (db RDB) AddQueued(o DBObject) (int64, error) {
+	if err := db.setTenant(o); err != nil {
+		return 0, err
+	}
+	query, err := upsertQuery(db.prefix, o)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := db.queue([]string{query}, false)
+	db.invalidate(o)
+	return seq, err
+}
+
+// maxQueueWaits is the most statements WaitFor queues to reach a sequence number
+const maxQueueWaits = 10
+
+// WaitFor blocks until the queued write of the sequence number is applied,
+// along with any others queued on the node before now. Queued writes are
+// applied in order, so this queues a statement of its own and waits for it,
+// again until its sequence number reaches seq, which a write queued on
+// another node may not, so it gives up after maxQueueWaits statements
+func (db RDB) WaitFor(seq int64) error {
+	if seq <= 0 {
+		return fmt.Errorf("invalid sequence number: %d", seq)
+	}
+	var applied int64
+	for i := 0; i < maxQueueWaits; i++ {
+		var err error
+		if applied, err = db.queue([]string{queueMarker}, true); err != nil {
+			return err
+		}
+		if applied >= seq {
+			return nil
+		}
+	}
+	return fmt.Errorf("queued write %d not applied, the queue reached %d", seq, applied)
+}
+
+// queue sends the statements to the leader's queue, waiting for them to be
+// applied if asked, returning the sequence number of the request. They are
+// logged if slow, though unless waited for only their queueing is timed
+func (db RDB) queue(queries []string, wait bool) (int64, error) {
+	body, err := json.Marshal(queries)
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{"queue": []string{""}}
+	if wait {
+		query.Set("wait", "")
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	defer db.logSlow(time.Now(), queries...)
+	resp, err := db.apiRequest("POST", "/db/execute", query, bytes.NewReader(body), header, true)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var reply queueReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return 0, fmt.Errorf("decoding reply: %w", err)
+	}
+	return reply.Sequence, reply.err()
+}
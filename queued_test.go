@@ -0,0 +1,93 @@
+package rqlobj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueued(t *testing.T) {
+	var seq int64
+	var queued [][]string
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Method != "POST" || r.URL.Path != "/db/execute" {
+			http.NotFound(w, r)
+			return
+		}
+		if _, ok := query["queue"]; !ok {
+			http.Error(w, "not queued", http.StatusBadRequest)
+			return
+		}
+		var statements []string
+		if err := json.NewDecoder(r.Body).Decode(&statements); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := query["wait"]; ok && (len(statements) != 1 || statements[0] != queueMarker) {
+			http.Error(w, "bad marker", http.StatusBadRequest)
+			return
+		}
+		queued = append(queued, statements)
+		seq++
+		fmt.Fprintf(w, `{"results": [], "sequence_number": %d}`, seq)
+	})
+
+	n, err := db.WriteQueued("insert into t values (1)", "insert into t values (2)")
+	if err != nil || n != 1 {
+		t.Fatalf("bad sequence number: %d (%v)", n, err)
+	}
+	if n, err := db.WriteQueued(); err != nil || n != 0 {
+		t.Errorf("nothing should be queued: %d (%v)", n, err)
+	}
+	o := &testStruct{Name: "queued", Kind: 3}
+	if n, err = db.AddQueued(o); err != nil || n != 2 {
+		t.Fatalf("bad sequence number: %d (%v)", n, err)
+	}
+	// the queue is waited on until it reaches the sequence number
+	if err := db.WaitFor(n + 2); err != nil {
+		t.Fatal(err)
+	}
+	added, _ := upsertQuery("", o)
+	if len(queued) != 4 || len(queued[0]) != 2 || queued[1][0] != added || queued[2][0] != queueMarker || queued[3][0] != queueMarker {
+		t.Errorf("bad statements queued: %q", queued)
+	}
+	if err := db.WaitFor(seq + maxQueueWaits + 1); err == nil {
+		t.Error("expected an error for a sequence number not reached")
+	}
+	if len(queued) != 4+maxQueueWaits {
+		t.Errorf("queued %d statements to wait, want %d", len(queued)-4, maxQueueWaits)
+	}
+	if err := db.WaitFor(0); err == nil {
+		t.Error("expected an error for an invalid sequence number")
+	}
+	if _, err := db.AddQueued(&tenantStruct{}); err != ErrNoTenant {
+		t.Errorf("expected ErrNoTenant but got %v", err)
+	}
+}
+
+func TestQueuedSlow(t *testing.T) {
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": [], "sequence_number": 1}`))
+	})
+	var slow bytes.Buffer
+	if _, err := db.WithSlowLog(time.Nanosecond, &slow).WriteQueued("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(slow.String(), "insert into t values (1)") {
+		t.Errorf("queued write not logged: %q", slow.String())
+	}
+}
+
+func TestQueuedError(t *testing.T) {
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": [], "error": "queue is full"}`))
+	})
+	if _, err := db.WriteQueued("insert into t values (1)"); err == nil || err.Error() != "queue is full" {
+		t.Errorf("expected the reply's error but got %v", err)
+	}
+}
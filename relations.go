@@ -0,0 +1,497 @@
+package rqlobj
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// inChunk is the most values put in a single "in" list
+const inChunk = 500
+
+// Relation describes objects related to another by a foreign key
+type Relation struct {
+	// Name is the struct member holding the related objects
+	Name string
+
+	// HasMany is true for the objects whose foreign key references
+	// this one, otherwise it is the object this one references
+	HasMany bool
+
+	// Column is the foreign key column, in the related table
+	// for HasMany, otherwise in this object's table
+	Column string
+
+	// Key is the referenced column, if not the primary key
+	Key string
+
+	// New returns a new related object
+	New func() DBObject
+
+	// Attach attaches the related object to this one
+	Attach func(this, related DBObject)
+
+	// Reset clears the related objects held, for HasMany
+	Reset func(this DBObject)
+
+	// Join is the association table of a many-to-many relation,
+	// Column and Key are unused when it is set
+	Join *JoinTable
+}
+
+// JoinTable is the association table of a many-to-many relation,
+// keyed by the primary keys of the objects it associates
+type JoinTable struct {
+	// Table is the name of the join table
+	Table string
+
+	// Column references the primary key of this object
+	Column string
+
+	// Other references the primary key of the related object
+	Other string
+
+	// Create has the statements to create the table and its indexes
+	Create []string
+}
+
+// Relater is implemented by objects with related objects, as generated by dbgen
+type Relater interface {
+	Relations() []Relation
+}
+
+// Preload loads the named relations (all of them if none are named) for the
+// objects in list, which is a pointer to a slice of objects, or a single object.
+// Each relation is loaded with a single query, rather than one per object
+func (db RDB) Preload(list interface{}, relations ...string) error {
+	objs, err := objectsOf(list)
+	if err != nil || len(objs) == 0 {
+		return err
+	}
+	relater, ok := objs[0].(Relater)
+	if !ok {
+		return fmt.Errorf("%T has no relations", objs[0])
+	}
+	all := relater.Relations()
+	if len(relations) == 0 {
+		for _, rel := range all {
+			relations = append(relations, rel.Name)
+		}
+	}
+	for _, name := range relations {
+		var found bool
+		for _, rel := range all {
+			if rel.Name == name {
+				found = true
+				if err := db.preload(objs, rel); err != nil {
+					return fmt.Errorf("preloading %s: %w", name, err)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("%T has no relation %q", objs[0], name)
+		}
+	}
+	return nil
+}
+
+// preload loads the related objects of the relation and attaches them
+func (db RDB) preload(objs []DBObject, rel Relation) error {
+	if rel.Join != nil {
+		return db.preloadJoin(objs, rel)
+	}
+	proto := rel.New()
+	// this is the column of objs to match against that of the related objects
+	this, that := rel.Column, rel.Key
+	if rel.HasMany {
+		this, that = rel.Key, rel.Column
+		if this == "" {
+			key, err := keyField(objs[0])
+			if err != nil {
+				return err
+			}
+			this = key
+		}
+		if rel.Reset != nil {
+			for _, o := range objs {
+				rel.Reset(o)
+			}
+		}
+	} else if that == "" {
+		key, err := keyField(proto)
+		if err != nil {
+			return err
+		}
+		that = key
+	}
+	byValue := make(map[string][]DBObject)
+	var values []interface{}
+	for _, o := range objs {
+		value := columnValue(o, this)
+		if value == nil {
+			continue
+		}
+		key := formatted(value)
+		if _, ok := byValue[key]; !ok {
+			values = append(values, value)
+		}
+		byValue[key] = append(byValue[key], o)
+	}
+	for len(values) > 0 {
+		n := len(values)
+		if n > inChunk {
+			n = inChunk
+		}
+		list := &objectList{proto: proto, create: rel.New}
+		where := fmt.Sprintf("%s in (%s)", that, fieldList(values[:n]...))
+		if err := db.ListQuery(list, where); err != nil {
+			return err
+		}
+		for _, related := range list.objs {
+			for _, o := range byValue[formatted(columnValue(related, that))] {
+				rel.Attach(o, related)
+			}
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// preloadJoin loads the objects associated through the join table and attaches them
+func (db RDB) preloadJoin(objs []DBObject, rel Relation) error {
+	proto := rel.New()
+	join := rel.Join
+	key, err := keyField(objs[0])
+	if err != nil {
+		return err
+	}
+	otherKey, err := keyField(proto)
+	if err != nil {
+		return err
+	}
+	if rel.Reset != nil {
+		for _, o := range objs {
+			rel.Reset(o)
+		}
+	}
+	byValue := make(map[string][]DBObject)
+	var values []interface{}
+	for _, o := range objs {
+		value := columnValue(o, key)
+		if value == nil {
+			continue
+		}
+		k := formatted(value)
+		if _, ok := byValue[k]; !ok {
+			values = append(values, value)
+		}
+		byValue[k] = append(byValue[k], o)
+	}
+	for len(values) > 0 {
+		n := len(values)
+		if n > inChunk {
+			n = inChunk
+		}
+		rows := &joinRows{
+			join:  join,
+			this:  reflect.TypeOf(values[0]),
+			other: reflect.TypeOf(columnValue(proto, otherKey)),
+		}
+		if err := db.ListQuery(rows, fmt.Sprintf("%s in (%s)", join.Column, fieldList(values[:n]...))); err != nil {
+			return err
+		}
+		// the related objects are fetched once, however many objects they are associated with
+		var others []interface{}
+		seen := make(map[string]struct{})
+		for _, row := range rows.rows {
+			k := formatted(row[1])
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				others = append(others, row[1])
+			}
+		}
+		related := make(map[string]DBObject, len(others))
+		for len(others) > 0 {
+			m := len(others)
+			if m > inChunk {
+				m = inChunk
+			}
+			list := &objectList{proto: proto, create: rel.New}
+			where := fmt.Sprintf("%s in (%s)", otherKey, fieldList(others[:m]...))
+			if err := db.ListQuery(list, where); err != nil {
+				return err
+			}
+			for _, o := range list.objs {
+				related[formatted(columnValue(o, otherKey))] = o
+			}
+			others = others[m:]
+		}
+		for _, row := range rows.rows {
+			other, ok := related[formatted(row[1])]
+			if !ok {
+				continue
+			}
+			for _, o := range byValue[formatted(row[0])] {
+				rel.Attach(o, other)
+			}
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// Link associates the related objects with the object through the join table
+// of the named many-to-many relation. Existing associations are left as is
+func (db RDB) Link(o DBObject, relation string, related ...DBObject) error {
+	if err := db.checkTenants(o, related); err != nil {
+		return err
+	}
+	queries, err := linkQueries(db.prefix, o, relation, related)
+	if err != nil || len(queries) == 0 {
+		return err
+	}
+	results, err := db.Write(queries...)
+	return writeError(err, results, queries)
+}
+
+// Unlink removes the associations between the object and the related objects
+// from the join table of the named many-to-many relation. The objects remain
+func (db RDB) Unlink(o DBObject, relation string, related ...DBObject) error {
+	if err := db.checkTenants(o, related); err != nil {
+		return err
+	}
+	queries, err := unlinkQueries(db.prefix, o, relation, related)
+	if err != nil || len(queries) == 0 {
+		return err
+	}
+	results, err := db.Write(queries...)
+	return writeError(err, results, queries)
+}
+
+// checkTenants returns an error unless the objects to associate belong to
+// the db's tenant, if their types are scoped to tenants
+func (db RDB) checkTenants(o DBObject, related []DBObject) error {
+	if err := db.checkTenant(o); err != nil {
+		return err
+	}
+	for _, r := range related {
+		if err := db.checkTenant(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyField returns the first key column of the object
+func keyField(o DBObject) (string, error) {
+	keys := o.KeyFields()
+	if len(keys) == 0 {
+		return "", ErrNoKeyField
+	}
+	return keys[0], nil
+}
+
+// keyValue returns the value of the first key of the object
+func keyValue(o DBObject) (interface{}, error) {
+	values := o.KeyValues()
+	if len(values) == 0 {
+		return nil, ErrNoKeyField
+	}
+	return values[0], nil
+}
+
+// joinRelation returns the named many-to-many relation of the object
+func joinRelation(o DBObject, name string) (*JoinTable, error) {
+	relater, ok := o.(Relater)
+	if !ok {
+		return nil, fmt.Errorf("%T has no relations", o)
+	}
+	for _, rel := range relater.Relations() {
+		if rel.Name == name {
+			if rel.Join == nil {
+				return nil, fmt.Errorf("%T relation %q is not many-to-many", o, name)
+			}
+			return rel.Join, nil
+		}
+	}
+	return nil, fmt.Errorf("%T has no relation %q", o, name)
+}
+
+// linkQueries returns the statements adding the associations to the join
+// table named with the prefix, in chunks
+func linkQueries(prefix string, o DBObject, relation string, related []DBObject) ([]string, error) {
+	join, err := joinRelation(o, relation)
+	if err != nil {
+		return nil, err
+	}
+	this, err := keyValue(o)
+	if err != nil {
+		return nil, err
+	}
+	var queries []string
+	for len(related) > 0 {
+		n := len(related)
+		if n > inChunk {
+			n = inChunk
+		}
+		rows := make([]string, n)
+		for i, r := range related[:n] {
+			other, err := keyValue(r)
+			if err != nil {
+				return nil, err
+			}
+			rows[i] = fmt.Sprintf("(%s, %s)", formatted(this), formatted(other))
+		}
+		const text = "insert or ignore into %s (%s, %s) values %s"
+		queries = append(queries, fmt.Sprintf(text, prefix+join.Table, join.Column, join.Other, strings.Join(rows, ", ")))
+		related = related[n:]
+	}
+	return queries, nil
+}
+
+// unlinkQueries returns the statements removing the associations from the
+// join table named with the prefix, in chunks
+func unlinkQueries(prefix string, o DBObject, relation string, related []DBObject) ([]string, error) {
+	join, err := joinRelation(o, relation)
+	if err != nil {
+		return nil, err
+	}
+	this, err := keyValue(o)
+	if err != nil {
+		return nil, err
+	}
+	var queries []string
+	for len(related) > 0 {
+		n := len(related)
+		if n > inChunk {
+			n = inChunk
+		}
+		others := make([]interface{}, n)
+		for i, r := range related[:n] {
+			if others[i], err = keyValue(r); err != nil {
+				return nil, err
+			}
+		}
+		const text = "delete from %s where %s = %s and %s in (%s)"
+		queries = append(queries, fmt.Sprintf(text, prefix+join.Table, join.Column, formatted(this), join.Other, fieldList(others...)))
+		related = related[n:]
+	}
+	return queries, nil
+}
+
+// joinTables returns the join tables of the objects' many-to-many relations,
+// each table once, even if declared by both sides of the relation
+func joinTables(objs []DBObject) []*JoinTable {
+	var tables []*JoinTable
+	seen := make(map[string]struct{})
+	for _, o := range objs {
+		relater, ok := o.(Relater)
+		if !ok {
+			continue
+		}
+		for _, rel := range relater.Relations() {
+			if rel.Join == nil {
+				continue
+			}
+			if _, ok := seen[rel.Join.Table]; ok {
+				continue
+			}
+			seen[rel.Join.Table] = struct{}{}
+			tables = append(tables, rel.Join)
+		}
+	}
+	return tables
+}
+
+// objectsOf returns the objects of a pointer to a slice of objects, or a single object
+func objectsOf(list interface{}) ([]DBObject, error) {
+	if o, ok := list.(DBObject); ok {
+		return []DBObject{o}, nil
+	}
+	if objs, ok := list.([]DBObject); ok {
+		return objs, nil
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%T is not a list of objects", list)
+	}
+	objs := make([]DBObject, v.Len())
+	for i := range objs {
+		item := v.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		o, ok := item.Interface().(DBObject)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a DBObject", item.Type())
+		}
+		objs[i] = o
+	}
+	return objs, nil
+}
+
+// columnValue returns the value of the object's column
+func columnValue(o DBObject, column string) interface{} {
+	receivers := o.Receivers()
+	for i, field := range strings.Split(o.SelectFields(), ",") {
+		if strings.TrimSpace(field) == column && i < len(receivers) {
+			v := reflect.ValueOf(receivers[i])
+			if v.Kind() != reflect.Ptr || v.IsNil() {
+				return nil
+			}
+			return v.Elem().Interface()
+		}
+	}
+	return nil
+}
+
+// objectList is a DBList of objects created as needed
+type objectList struct {
+	proto  DBObject
+	create func() DBObject
+	objs   []DBObject
+}
+
+func (l *objectList) SQLGet(extra string) string {
+	query := fmt.Sprintf("select %s from %s", l.proto.SelectFields(), l.proto.TableName())
+	if extra != "" {
+		query += " where " + extra
+	}
+	return query
+}
+
+func (l *objectList) SQLResults(fn func(...interface{}) error) error {
+	o := l.create()
+	if err := fn(o.Receivers()...); err != nil {
+		return err
+	}
+	l.objs = append(l.objs, o)
+	return nil
+}
+
+// joinRows is a DBList of the key pairs of a join table
+type joinRows struct {
+	join        *JoinTable
+	this, other reflect.Type
+	rows        [][2]interface{}
+}
+
+func (j *joinRows) SQLGet(extra string) string {
+	query := fmt.Sprintf("select %s, %s from %s", j.join.Column, j.join.Other, j.join.Table)
+	if extra != "" {
+		query += " where " + extra
+	}
+	return query
+}
+
+func (j *joinRows) SQLResults(fn func(...interface{}) error) error {
+	this, other := reflect.New(j.this), reflect.New(j.other)
+	if err := fn(this.Interface(), other.Interface()); err != nil {
+		return err
+	}
+	j.rows = append(j.rows, [2]interface{}{this.Elem().Interface(), other.Elem().Interface()})
+	return nil
+}
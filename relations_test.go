@@ -0,0 +1,140 @@
+package rqlobj
+
+import (
+	"testing"
+)
+
+func TestObjectsOf(t *testing.T) {
+	list := _testStruct{{ID: 1}, {ID: 2}}
+	objs, err := objectsOf(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected 2 objects but got %d", len(objs))
+	}
+	// objects must refer to the list elements so related objects attach to them
+	objs[1].SetPrimary(20)
+	if list[1].ID != 20 {
+		t.Errorf("object is a copy of the list element")
+	}
+	if objs, err := objectsOf(&testStruct{ID: 3}); err != nil || len(objs) != 1 {
+		t.Errorf("single object: %v %v", objs, err)
+	}
+	if _, err := objectsOf(&[]int{1}); err == nil {
+		t.Errorf("expected error for a list of ints")
+	}
+}
+
+func TestColumnValue(t *testing.T) {
+	s := &testStruct{ID: 7, Name: "seven", Kind: 3}
+	if v := columnValue(s, "kind"); v != 3 {
+		t.Errorf("kind: got %v", v)
+	}
+	if v := columnValue(s, "name"); v != "seven" {
+		t.Errorf("name: got %v", v)
+	}
+	if v := columnValue(s, "nope"); v != nil {
+		t.Errorf("unknown column: got %v", v)
+	}
+	list := &objectList{proto: s}
+	const query = "select id,name,kind,data,modified from test_structs where kind in (1, 2)"
+	if got := list.SQLGet("kind in (1, 2)"); got != query {
+		t.Errorf("got %s\nwant %s", got, query)
+	}
+}
+
+// groupedStruct has a many-to-many relation with itself
+type groupedStruct struct {
+	testStruct
+}
+
+func (g *groupedStruct) Relations() []Relation {
+	return []Relation{{
+		Name: "Groups",
+		New:  func() DBObject { return new(groupedStruct) },
+		Join: &JoinTable{
+			Table:  "test_groups",
+			Column: "test_id",
+			Other:  "group_id",
+			Create: []string{"create table if not exists test_groups (test_id integer, group_id integer)"},
+		},
+	}}
+}
+
+func TestLinkQueries(t *testing.T) {
+	o := &groupedStruct{testStruct{ID: 1}}
+	related := []DBObject{&groupedStruct{testStruct{ID: 2}}, &groupedStruct{testStruct{ID: 3}}}
+	queries, err := linkQueries("", o, "Groups", related)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const link = "insert or ignore into test_groups (test_id, group_id) values (1, 2), (1, 3)"
+	if len(queries) != 1 || queries[0] != link {
+		t.Errorf("got %q\nwant %s", queries, link)
+	}
+	queries, err = unlinkQueries("", o, "Groups", related)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const unlink = "delete from test_groups where test_id = 1 and group_id in (2, 3)"
+	if len(queries) != 1 || queries[0] != unlink {
+		t.Errorf("got %q\nwant %s", queries, unlink)
+	}
+	if _, err := linkQueries("", o, "Nope", related); err == nil {
+		t.Errorf("expected error for an unknown relation")
+	}
+	if _, err := linkQueries("", &testStruct{ID: 1}, "Groups", related); err == nil {
+		t.Errorf("expected error for an object without relations")
+	}
+}
+
+func TestJoinTables(t *testing.T) {
+	objs := []DBObject{&groupedStruct{}, &testStruct{}, &groupedStruct{}}
+	tables := joinTables(objs)
+	if len(tables) != 1 || tables[0].Table != "test_groups" {
+		t.Errorf("expected the join table once but got %v", tables)
+	}
+	rows := &joinRows{join: tables[0]}
+	const query = "select test_id, group_id from test_groups where test_id in (1)"
+	if got := rows.SQLGet("test_id in (1)"); got != query {
+		t.Errorf("got %s\nwant %s", got, query)
+	}
+}
+
+// keylessStruct is related to others, but has no key to relate them by
+type keylessStruct struct {
+	groupedStruct
+}
+
+func (k *keylessStruct) KeyFields() []string {
+	return nil
+}
+
+func (k *keylessStruct) KeyValues() []interface{} {
+	return nil
+}
+
+func TestKeylessRelations(t *testing.T) {
+	var db RDB
+	keyless := func() DBObject { return new(keylessStruct) }
+	objs := []DBObject{keyless()}
+	for name, rel := range map[string]Relation{
+		"has many":     {HasMany: true, Column: "kind", New: keyless},
+		"belongs to":   {Column: "kind", New: keyless},
+		"many to many": {Join: &JoinTable{Table: "test_groups"}, New: keyless},
+	} {
+		if err := db.preload(objs, rel); err != ErrNoKeyField {
+			t.Errorf("%s: expected ErrNoKeyField but got %v", name, err)
+		}
+	}
+	o := &groupedStruct{testStruct{ID: 1}}
+	for _, fn := range []func(string, DBObject, string, []DBObject) ([]string, error){linkQueries, unlinkQueries} {
+		if _, err := fn("", o, "Groups", objs); err != ErrNoKeyField {
+			t.Errorf("expected ErrNoKeyField but got %v", err)
+		}
+		if _, err := fn("", objs[0], "Groups", []DBObject{o}); err != ErrNoKeyField {
+			t.Errorf("expected ErrNoKeyField but got %v", err)
+		}
+	}
+}
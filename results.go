@@ -0,0 +1,244 @@
+package rqlobj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// statementResult is the result of a statement run by the query
+// or execute endpoints, as decoded from json
+type statementResult struct {
+	Columns      []string        `json:"columns"`
+	Types        []string        `json:"types"`
+	Values       [][]interface{} `json:"values"`
+	LastInsertID int64           `json:"last_insert_id"`
+	RowsAffected int64           `json:"rows_affected"`
+	Time         float64         `json:"time"`
+	Error        string          `json:"error"`
+}
+
+// statementsReply is the reply of the query and execute endpoints
+type statementsReply struct {
+	Results []statementResult `json:"results"`
+	Error   string            `json:"error"`
+}
+
+// statements posts the statements to the query or execute endpoint, in a
+// transaction if asked, returning their results, and if any failed, an error
+func (db RDB) statements(path string, queries []string, transaction bool) ([]statementResult, error) {
+	body, err := json.Marshal(queries)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"timings": []string{""}}
+	if transaction {
+		query.Set("transaction", "")
+	}
+	if db.level != "" {
+		query.Set("level", db.level)
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	resp, err := db.apiRequest("POST", path, query, bytes.NewReader(body), header, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var reply statementsReply
+	// numbers are kept as text, as integers beyond 2^53 do not survive float64
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("decoding reply: %w", err)
+	}
+	if reply.Error != "" {
+		return reply.Results, fmt.Errorf("%s", reply.Error)
+	}
+	failed := 0
+	for _, result := range reply.Results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return reply.Results, fmt.Errorf("there were %d statement errors", failed)
+	}
+	return reply.Results, nil
+}
+
+// writeResults returns the results of the execute endpoint as gorqlite's,
+// which Write returns
+func writeResults(results []statementResult) []gorqlite.WriteResult {
+	written := make([]gorqlite.WriteResult, len(results))
+	for i, result := range results {
+		written[i] = gorqlite.WriteResult{
+			Timing:       result.Time,
+			RowsAffected: result.RowsAffected,
+			LastInsertID: result.LastInsertID,
+		}
+		if result.Error != "" {
+			written[i].Err = fmt.Errorf("%s", result.Error)
+		}
+	}
+	return written
+}
+
+// resultSet is the result of a query, read a row at a time
+type resultSet struct {
+	columns []string
+	types   []string
+	values  [][]interface{}
+	read    int // the rows read by Next, the last being the current row
+	Err     error
+}
+
+// newResultSet returns the result set of a statement
+func newResultSet(result statementResult) resultSet {
+	rs := resultSet{
+		columns: result.Columns,
+		types:   result.Types,
+		values:  result.Values,
+	}
+	if result.Error != "" {
+		rs.Err = fmt.Errorf("%s", result.Error)
+	}
+	return rs
+}
+
+// Next moves to the next row, returning false if there are no more
+func (r *resultSet) Next() bool {
+	if r.read >= len(r.values) {
+		return false
+	}
+	r.read++
+	return true
+}
+
+// Columns returns the names of the columns
+func (r *resultSet) Columns() []string {
+	return r.columns
+}
+
+// Types returns the declared types of the columns
+func (r *resultSet) Types() []string {
+	return r.types
+}
+
+// Row returns the values of the current row, as decoded from json
+func (r *resultSet) Row() []interface{} {
+	if r.read == 0 {
+		return nil
+	}
+	return r.values[r.read-1]
+}
+
+// Scan sets the receivers to the values of the current row, leaving
+// those of nulls as they are. Receivers are pointers to time.Time,
+// int, int64, float64 or string
+func (r *resultSet) Scan(dest ...interface{}) error {
+	row := r.Row()
+	if row == nil {
+		return fmt.Errorf("no current row to scan")
+	}
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d columns but got %d receivers", len(row), len(dest))
+	}
+	for i, src := range row {
+		if src == nil {
+			continue
+		}
+		if err := scanValue(dest[i], src); err != nil {
+			return fmt.Errorf("column %s: %w", r.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// scanValue sets the receiver to the value, as decoded from json
+func scanValue(dest, src interface{}) error {
+	switch d := dest.(type) {
+	case *time.Time:
+		t, err := toTime(src)
+		if err != nil {
+			return err
+		}
+		*d = t
+	case *int:
+		i, err := scanInt(src)
+		if err != nil {
+			return err
+		}
+		*d = int(i)
+	case *int64:
+		i, err := scanInt(src)
+		if err != nil {
+			return err
+		}
+		*d = i
+	case *float64:
+		switch src := src.(type) {
+		case float64:
+			*d = src
+		case json.Number:
+			f, err := src.Float64()
+			if err != nil {
+				return err
+			}
+			*d = f
+		case string:
+			f, err := strconv.ParseFloat(src, 64)
+			if err != nil {
+				return err
+			}
+			*d = f
+		default:
+			return fmt.Errorf("invalid float %T: %v", src, src)
+		}
+	case *string:
+		text, ok := src.(string)
+		if !ok {
+			return fmt.Errorf("invalid string %T: %v", src, src)
+		}
+		*d = text
+	default:
+		return fmt.Errorf("unknown receiver type %T", dest)
+	}
+	return nil
+}
+
+// scanInt returns the integer of the value, as decoded from json
+func scanInt(src interface{}) (int64, error) {
+	switch src := src.(type) {
+	case json.Number:
+		if i, err := src.Int64(); err == nil {
+			return i, nil
+		}
+		// a whole number written as a real, e.g., 3.0
+		f, err := src.Float64()
+		if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1<<63 {
+			return 0, fmt.Errorf("invalid integer: %s", src)
+		}
+		return int64(f), nil
+	case float64:
+		return int64(src), nil
+	case string:
+		return strconv.ParseInt(src, 10, 64)
+	}
+	return 0, fmt.Errorf("invalid integer %T: %v", src, src)
+}
+
+// numberValue returns the json number as an int64 if it is an integer,
+// otherwise as a float64
+func numberValue(n json.Number) (interface{}, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	return n.Float64()
+}
@@ -0,0 +1,127 @@
+package rqlobj
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatements(t *testing.T) {
+	var query string
+	db := apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		var statements []string
+		json.NewDecoder(r.Body).Decode(&statements)
+		if statements[0] == "bogus" {
+			fmt.Fprint(w, `{"results": [{"error": "near \"bogus\": syntax error"}, {}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"results": [{"last_insert_id": 3, "rows_affected": 1, "time": 0.5}]}`)
+	})
+	db.level = "strong"
+	results, err := db.statements("/db/execute", []string{"insert into t values (3)"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "level=strong&timings=&transaction=" {
+		t.Errorf("bad query: %s", query)
+	}
+	written := writeResults(results)
+	if len(written) != 1 || written[0].LastInsertID != 3 || written[0].RowsAffected != 1 || written[0].Timing != 0.5 {
+		t.Errorf("bad results: %+v", written)
+	}
+
+	if _, err := db.statements("/db/execute", []string{"pragma foreign_keys=on"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if query != "level=strong&timings=" {
+		t.Errorf("bad query: %s", query)
+	}
+
+	results, err = db.statements("/db/execute", []string{"bogus", "select 1"}, true)
+	if err == nil || err.Error() != "there were 1 statement errors" {
+		t.Errorf("expected the statement errors but got %v", err)
+	}
+	if written := writeResults(results); len(written) != 2 || written[0].Err == nil || written[1].Err != nil {
+		t.Errorf("bad results: %+v", written)
+	}
+}
+
+func TestResultSet(t *testing.T) {
+	rs := newResultSet(statementResult{
+		Columns: []string{"id", "name", "ratio", "modified"},
+		Types:   []string{"integer", "text", "real", "datetime"},
+		Values: [][]interface{}{
+			{float64(7), "seven", 0.5, "2023-11-14 22:13:20"},
+			{"8", nil, "1.5", float64(1700000000)},
+		},
+	})
+	if rs.Row() != nil {
+		t.Error("row before Next")
+	}
+	var id int64
+	var name string
+	var ratio float64
+	var modified time.Time
+	if err := rs.Scan(&id, &name, &ratio, &modified); err == nil {
+		t.Error("expected an error scanning without a current row")
+	}
+	want := time.Unix(1700000000, 0)
+	for i := int64(7); rs.Next(); i++ {
+		if err := rs.Scan(&id, &name, &ratio, &modified); err != nil {
+			t.Fatal(err)
+		}
+		// nulls leave their receiver as is
+		if id != i || name != "seven" || ratio != float64(i-7)+0.5 || !modified.Equal(want) {
+			t.Errorf("row %d: got %d %q %v %v", i, id, name, ratio, modified)
+		}
+	}
+	if rs.Next() {
+		t.Error("read past the last row")
+	}
+	if err := rs.Scan(&id); err == nil {
+		t.Error("expected an error for too few receivers")
+	}
+
+	bad := newResultSet(statementResult{Columns: []string{"name"}, Values: [][]interface{}{{float64(1)}}})
+	bad.Next()
+	if err := bad.Scan(&name); err == nil {
+		t.Error("expected an error scanning a number as a string")
+	}
+	if failed := newResultSet(statementResult{Error: "no such table: t"}); failed.Err == nil {
+		t.Error("statement error not kept")
+	}
+}
+
+func TestLargeIntegers(t *testing.T) {
+	db, local := sqliteServer(t)
+	const big = 1<<53 + 1
+	if _, err := local.Exec("create table t (id integer primary key, ratio real); insert into t values (?, 0.25)", int64(big)); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	var ratio float64
+	if err := db.get([]interface{}{&id, &ratio}, "select id, ratio from t"); err != nil {
+		t.Fatal(err)
+	}
+	if id != big || ratio != 0.25 {
+		t.Errorf("scanned %d %v, want %d 0.25", id, ratio, int64(big))
+	}
+	rows, err := db.QueryRows("select id, ratio, id + 0 from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := rows.Values[0]; row[0] != int64(big) || row[1] != 0.25 || row[2] != int64(big) {
+		t.Errorf("got row %#v", row)
+	}
+	for text, want := range map[string]int64{"3": 3, "3.0": 3, "-9223372036854775808": -1 << 63} {
+		if got, err := scanInt(json.Number(text)); err != nil || got != want {
+			t.Errorf("%s: got %d (%v), want %d", text, got, err, want)
+		}
+	}
+	if _, err := scanInt(json.Number("3.5")); err == nil {
+		t.Error("expected an error for a fraction")
+	}
+}
@@ -0,0 +1,144 @@
+package rqlobj
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rqlite/gorqlite"
+)
+
+var references = regexp.MustCompile(`(?i)\breferences\s+["'\x60]?(\w+)`)
+
+// foreignTables returns the tables referenced by the object's table definition
+func foreignTables(o DBObject) []string {
+	var tables []string
+	for _, match := range references.FindAllStringSubmatch(o.SQLCreate(), -1) {
+		tables = append(tables, match[1])
+	}
+	return tables
+}
+
+// tableOrder sorts the objects so that tables come after the tables
+// they reference. References to tables not in the list are ignored,
+// they are presumed to exist already
+func tableOrder(objs []DBObject) ([]DBObject, error) {
+	index := make(map[string]int, len(objs))
+	for i, o := range objs {
+		index[o.TableName()] = i
+	}
+	// pending counts the unresolved references of each table
+	pending := make([]int, len(objs))
+	dependents := make([][]int, len(objs))
+	for i, o := range objs {
+		seen := make(map[int]struct{})
+		for _, table := range foreignTables(o) {
+			j, ok := index[table]
+			if !ok || j == i {
+				continue
+			}
+			if _, ok := seen[j]; ok {
+				continue
+			}
+			seen[j] = struct{}{}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	ordered := make([]DBObject, 0, len(objs))
+	done := make([]bool, len(objs))
+	for len(ordered) < len(objs) {
+		progress := false
+		// take the first ready table each pass to keep the given order where possible
+		for i, o := range objs {
+			if done[i] || pending[i] > 0 {
+				continue
+			}
+			done[i] = true
+			progress = true
+			ordered = append(ordered, o)
+			for _, j := range dependents[i] {
+				pending[j]--
+			}
+			break
+		}
+		if !progress {
+			var cycle []string
+			for i, o := range objs {
+				if !done[i] {
+					cycle = append(cycle, o.TableName())
+				}
+			}
+			return nil, fmt.Errorf("%w: %s", ErrSchemaCycle, strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// CreateTables creates the tables for the objects in a single transaction,
+// with tables created ahead of the tables whose foreign keys reference them.
+// Any indexes, full text indexes and changelogs the objects declare are
+// created along with their tables, and the join tables of their many-to-many
+// relations after all of them
+func (db RDB) CreateTables(objs ...DBObject) error {
+	ordered, err := tableOrder(objs)
+	if err != nil {
+		return err
+	}
+	queries := make([]string, 0, len(ordered))
+	for _, o := range ordered {
+		queries = append(queries, o.SQLCreate())
+		if indexer, ok := o.(Indexer); ok {
+			queries = append(queries, indexer.SQLIndexes()...)
+		}
+		if searcher, ok := o.(Searcher); ok {
+			queries = append(queries, searcher.SQLFTS()...)
+		}
+		if logger, ok := o.(ChangeLogger); ok {
+			queries = append(queries, logger.SQLChanges()...)
+		}
+	}
+	for _, join := range joinTables(ordered) {
+		queries = append(queries, join.Create...)
+	}
+	queries = namespaceAll(db.prefix, queries)
+	results, err := db.Write(queries...)
+	return writeError(err, results, queries)
+}
+
+// DropTables drops the tables for the objects in a single transaction,
+// in the reverse order of their creation
+func (db RDB) DropTables(objs ...DBObject) error {
+	ordered, err := tableOrder(objs)
+	if err != nil {
+		return err
+	}
+	queries := make([]string, 0, len(ordered))
+	for _, join := range joinTables(ordered) {
+		queries = append(queries, "drop table if exists "+db.prefix+join.Table)
+	}
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if _, ok := ordered[i].(Searcher); ok {
+			queries = append(queries, "drop table if exists "+db.Table(ordered[i])+ftsSuffix)
+		}
+		if _, ok := ordered[i].(ChangeLogger); ok {
+			queries = append(queries, "drop table if exists "+db.Table(ordered[i])+changesSuffix)
+		}
+		queries = append(queries, "drop table if exists "+db.Table(ordered[i]))
+	}
+	results, err := db.Write(queries...)
+	return writeError(err, results, queries)
+}
+
+// writeError returns the error of a Write, detailing the first statement that failed
+func writeError(err error, results []gorqlite.WriteResult, queries []string) error {
+	if err == nil {
+		return nil
+	}
+	for i, result := range results {
+		if result.Err != nil && i < len(queries) {
+			return fmt.Errorf("%w: %v: %s", err, result.Err, queries[i])
+		}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package rqlobj
+
+import (
+	"errors"
+	"testing"
+)
+
+// tableObject stands in for generated objects with a given table definition
+type tableObject struct {
+	testStruct
+	table  string
+	create string
+}
+
+func (o *tableObject) TableName() string {
+	return o.table
+}
+
+func (o *tableObject) SQLCreate() string {
+	return o.create
+}
+
+func newTable(name, columns string) *tableObject {
+	return &tableObject{
+		table:  name,
+		create: "create table if not exists " + name + " (\n" + columns + "\n);",
+	}
+}
+
+func tableNames(objs []DBObject) []string {
+	names := make([]string, len(objs))
+	for i, o := range objs {
+		names[i] = o.TableName()
+	}
+	return names
+}
+
+func TestTableOrder(t *testing.T) {
+	hosts := newTable("hosts", "  id integer primary key,\n  site_id integer REFERENCES sites(id) ON UPDATE CASCADE")
+	sites := newTable("sites", "  id integer primary key,\n  region_id integer REFERENCES regions(id)")
+	regions := newTable("regions", "  id integer primary key,\n  parent integer REFERENCES regions(id)")
+	users := newTable("users", "  id integer primary key,\n  org integer REFERENCES orgs(id)")
+	ordered, err := tableOrder([]DBObject{hosts, users, sites, regions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tableNames(ordered)
+	want := []string{"users", "regions", "sites", "hosts"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTableCycle(t *testing.T) {
+	a := newTable("a", "  id integer primary key,\n  b_id integer REFERENCES b(id)")
+	b := newTable("b", "  id integer primary key,\n  a_id integer REFERENCES a(id)")
+	c := newTable("c", "  id integer primary key")
+	_, err := tableOrder([]DBObject{a, b, c})
+	if !errors.Is(err, ErrSchemaCycle) {
+		t.Fatalf("expected cycle error but got: %v", err)
+	}
+	t.Log(err)
+}
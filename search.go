@@ -0,0 +1,148 @@
+package rqlobj
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ftsSuffix names the full text index of a table, as generated by dbgen
+const ftsSuffix = "_fts"
+
+// Searcher is implemented by objects with columns indexed
+// for full text search, as generated by dbgen
+type Searcher interface {
+	// FTSColumns returns the columns indexed for full text search
+	FTSColumns() []string
+
+	// SQLFTS returns the queries to create the full text index
+	SQLFTS() []string
+}
+
+// SearchOptions refine a full text search
+type SearchOptions struct {
+	// Limit is the most objects returned, if not zero
+	Limit int
+
+	// Offset is the number of objects skipped
+	Offset int
+
+	// Where is extra criteria, as for ListQuery, on the object's table
+	// aliased as t, e.g., "t.kind = 2"
+	Where string
+
+	// Snippet is the indexed column to return highlighted snippets of
+	Snippet string
+
+	// Before and After mark the matches in snippets, "<b>" and "</b>" by default
+	Before, After string
+
+	// Tokens is the most tokens in a snippet, 16 by default
+	Tokens int
+}
+
+// Search finds the objects matching the FTS5 query, best matches first as
+// ranked by bm25, and appends them to list, a pointer to a slice of objects.
+// If a snippet column is given, the snippets of each object are returned
+// in the same order
+func (db RDB) Search(list interface{}, match string, opts *SearchOptions) ([]string, error) {
+	create, add, err := appender(list)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	proto := create()
+	scope, err := db.scope(proto)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		scoped := *opts
+		scoped.Where = "t." + scope
+		if opts.Where != "" {
+			scoped.Where += " and (" + opts.Where + ")"
+		}
+		opts = &scoped
+	}
+	query, err := searchQuery(db.prefix, proto, match, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.queryResult(query)
+	if err != nil {
+		return nil, err
+	}
+	var snippets []string
+	for result.Next() {
+		o := create()
+		dest := o.Receivers()
+		var snippet string
+		if opts.Snippet != "" {
+			dest = append(dest, &snippet)
+		}
+		if err := scan(&result, dest...); err != nil {
+			return nil, err
+		}
+		snapshot(o)
+		add(o)
+		if opts.Snippet != "" {
+			snippets = append(snippets, snippet)
+		}
+	}
+	return snippets, nil
+}
+
+// searchQuery returns the query for objects like o matching the FTS5 query,
+// in the tables named with the prefix
+func searchQuery(prefix string, o DBObject, match string, opts *SearchOptions) (string, error) {
+	searcher, ok := o.(Searcher)
+	if !ok {
+		return "", fmt.Errorf("%T has no full text index", o)
+	}
+	keys := o.KeyFields()
+	if len(keys) != 1 {
+		return "", fmt.Errorf("%T must have a single key for full text search", o)
+	}
+	table := prefix + o.TableName()
+	fts := table + ftsSuffix
+	columns := selectColumns(o)
+	for i, column := range columns {
+		columns[i] = "t." + column
+	}
+	if opts.Snippet != "" {
+		index := -1
+		for i, column := range searcher.FTSColumns() {
+			if column == opts.Snippet {
+				index = i
+			}
+		}
+		if index < 0 {
+			return "", fmt.Errorf("%T does not index %q for full text search", o, opts.Snippet)
+		}
+		before, after, tokens := opts.Before, opts.After, opts.Tokens
+		if before == "" && after == "" {
+			before, after = "<b>", "</b>"
+		}
+		if tokens <= 0 {
+			tokens = 16
+		}
+		const text = "snippet(%s, %d, %s, %s, '...', %d)"
+		columns = append(columns, fmt.Sprintf(text, fts, index, formatted(before), formatted(after), tokens))
+	}
+	var query strings.Builder
+	const text = "select %s from %s join %s t on t.%s = %s.rowid where %s match %s"
+	fmt.Fprintf(&query, text, join(columns), fts, table, keys[0], fts, fts, formatted(match))
+	if opts.Where != "" {
+		query.WriteString(" and (" + namespaceSQL(prefix, opts.Where) + ")")
+	}
+	fmt.Fprintf(&query, " order by bm25(%s)", fts)
+	if opts.Limit > 0 || opts.Offset > 0 {
+		limit := opts.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		fmt.Fprintf(&query, " limit %d offset %d", limit, opts.Offset)
+	}
+	return query.String(), nil
+}
@@ -0,0 +1,58 @@
+package rqlobj
+
+import (
+	"testing"
+)
+
+// searchStruct indexes its name and data for full text search, as generated by dbgen
+type searchStruct struct {
+	testStruct
+}
+
+func (o *searchStruct) FTSColumns() []string {
+	return []string{"name", "data"}
+}
+
+func (o *searchStruct) SQLFTS() []string {
+	return nil
+}
+
+func TestSearchQuery(t *testing.T) {
+	const columns = "t.id,t.name,t.kind,t.data,t.modified"
+	const from = " from test_structs_fts join test_structs t on t.id = test_structs_fts.rowid where test_structs_fts match 'rack*'"
+	const order = " order by bm25(test_structs_fts)"
+	tests := []struct {
+		opts  SearchOptions
+		query string
+	}{
+		{SearchOptions{}, "select " + columns + from + order},
+		{
+			SearchOptions{Where: "t.kind = 2", Limit: 10, Offset: 20},
+			"select " + columns + from + " and (t.kind = 2)" + order + " limit 10 offset 20",
+		},
+		{SearchOptions{Offset: 5}, "select " + columns + from + order + " limit -1 offset 5"},
+		{
+			SearchOptions{Snippet: "data"},
+			"select " + columns + ",snippet(test_structs_fts, 1, '<b>', '</b>', '...', 16)" + from + order,
+		},
+		{
+			SearchOptions{Snippet: "name", Before: "[", After: "]", Tokens: 8},
+			"select " + columns + ",snippet(test_structs_fts, 0, '[', ']', '...', 8)" + from + order,
+		},
+	}
+	for _, test := range tests {
+		query, err := searchQuery("", &searchStruct{}, "rack*", &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != test.query {
+			t.Errorf("got %s\nwant %s", query, test.query)
+		}
+	}
+	if _, err := searchQuery("", &searchStruct{}, "rack", &SearchOptions{Snippet: "kind"}); err == nil {
+		t.Error("expected an error for a column that is not indexed")
+	}
+	if _, err := searchQuery("", &testStruct{}, "rack", &SearchOptions{}); err == nil {
+		t.Error("expected an error for an object without a full text index")
+	}
+}
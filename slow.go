@@ -0,0 +1,72 @@
+package rqlobj
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// slowLog records the statements taking longer than its threshold
+type slowLog struct {
+	threshold time.Duration
+	log       *log.Logger // the db's logger is used if nil
+}
+
+// WithSlowLog returns a copy of the db that logs the statements it runs
+// that take longer than the threshold, timed from the request to the reply,
+// along with how long they took. Statements run together are logged together.
+// The db's logger is used if w is nil, and a threshold of zero stops logging
+func (db RDB) WithSlowLog(threshold time.Duration, w io.Writer) RDB {
+	db.slow = nil
+	if threshold > 0 {
+		db.slow = &slowLog{threshold: threshold}
+		if w != nil {
+			db.slow.log = log.New(w, "", log.LstdFlags)
+		}
+	}
+	return db
+}
+
+// logSlow logs the statements if they were started longer ago than the threshold
+func (db RDB) logSlow(start time.Time, queries ...string) {
+	if db.slow == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed < db.slow.threshold {
+		return
+	}
+	logger := db.slow.log
+	if logger == nil {
+		logger = db._log
+	}
+	if logger != nil {
+		logger.Printf("slow query (%s): %s\n", elapsed.Round(time.Millisecond), strings.Join(queries, "; "))
+	}
+}
+
+// query runs the queries, logging them if slow
+func (db RDB) query(queries ...string) ([]resultSet, error) {
+	start := time.Now()
+	results, err := db.statements("/db/query", queries, true)
+	db.logSlow(start, queries...)
+	sets := make([]resultSet, len(results))
+	for i, result := range results {
+		sets[i] = newResultSet(result)
+	}
+	return sets, err
+}
+
+// queryOne runs the query, logging it if slow
+func (db RDB) queryOne(query string) (resultSet, error) {
+	results, err := db.query(query)
+	if len(results) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no result for query: %s", query)
+		}
+		return resultSet{}, err
+	}
+	return results[0], err
+}
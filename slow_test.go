@@ -0,0 +1,28 @@
+package rqlobj
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	var buf bytes.Buffer
+	db := RDB{}.WithSlowLog(time.Second, &buf)
+	db.logSlow(time.Now(), "select 1")
+	if buf.Len() != 0 {
+		t.Errorf("fast query logged: %s", buf.String())
+	}
+	db.logSlow(time.Now().Add(-2*time.Second), "insert into t values (1)", "insert into t values (2)")
+	const want = "): insert into t values (1); insert into t values (2)\n"
+	if !strings.Contains(buf.String(), "slow query (2") || !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("bad log: %q", buf.String())
+	}
+
+	buf.Reset()
+	db.WithSlowLog(0, &buf).logSlow(time.Now().Add(-time.Hour), "select 1")
+	if buf.Len() != 0 {
+		t.Errorf("logged with no threshold: %s", buf.String())
+	}
+}
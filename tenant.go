@@ -0,0 +1,193 @@
+package rqlobj
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TenantScoped is implemented by objects that belong to a tenant,
+// as generated by dbgen for members tagged tenant
+type TenantScoped interface {
+	// TenantColumn returns the column holding the object's tenant
+	TenantColumn() string
+}
+
+// ForTenant returns a copy of the db scoped to the tenant, which is the
+// value of the tenant column of the objects it works with. Objects of types
+// scoped to tenants are only loaded, listed, searched, updated and deleted if
+// they belong to the tenant, and are given the tenant when added or imported.
+// Only the changes to the tenant's objects are read from their changelogs by
+// Changes and Watch. A db that is not scoped refuses those objects with
+// ErrNoTenant, and a nil tenant removes the scope. Raw queries are not scoped
+func (db RDB) ForTenant(tenant interface{}) RDB {
+	db.tenant = tenant
+	return db
+}
+
+// scope returns the condition restricting objects like o to the db's tenant,
+// or nothing if their type is not scoped to tenants
+func (db RDB) scope(o DBObject) (string, error) {
+	scoped, ok := o.(TenantScoped)
+	if !ok {
+		return "", nil
+	}
+	if db.tenant == nil {
+		return "", ErrNoTenant
+	}
+	return scoped.TenantColumn() + "=" + formatted(db.tenant), nil
+}
+
+// changesScope returns the condition restricting the changes to objects
+// like o logged in their changelog to those of the db's tenant, or nothing
+// if their type is not scoped to tenants. Changes log the tenant of their
+// object, even when deleted
+func (db RDB) changesScope(o DBObject) (string, error) {
+	scoped, ok := o.(TenantScoped)
+	if !ok {
+		return "", nil
+	}
+	if db.tenant == nil {
+		return "", ErrNoTenant
+	}
+	return fmt.Sprintf("json_extract(object, '$.%s')=%s", scoped.TenantColumn(), formatted(db.tenant)), nil
+}
+
+// scopeWhere returns the criteria given to ListQuery with their conditions
+// restricted by the scope, if any, ahead of any grouping, order or limit, so
+// that those only count the rows in scope. The conditions are parenthesized
+// so that none can undo the scope
+func scopeWhere(list DBList, where, scope string) string {
+	if scope == "" {
+		return where
+	}
+	where = strings.TrimRight(strings.TrimSpace(where), ";")
+	var head string
+	if !addsWhere(list) {
+		// the criteria follow the table as is, e.g., joins, with any
+		// conditions after a where, as for the lists generated by dbgen
+		i := topLevel(where, "where")
+		if i >= 0 {
+			head, where = where[:i], where[i+len("where"):]
+		} else if i = topLevel(where, "group", "order", "limit"); i >= 0 {
+			head, where = where[:i], where[i:]
+		} else {
+			head, where = where, ""
+		}
+		if head = strings.TrimSpace(head); head != "" {
+			head += " "
+		}
+		head += "where "
+	}
+	cond, tail := where, ""
+	if i := topLevel(where, "group", "order", "limit"); i >= 0 {
+		cond, tail = where[:i], " "+where[i:]
+	}
+	if cond = strings.TrimSpace(cond); cond != "" {
+		scope = "(" + cond + ") and " + scope
+	}
+	return head + scope + tail
+}
+
+// addsWhere returns true if the list's SQLGet adds the where
+// preceding the criteria it is given
+func addsWhere(list DBList) bool {
+	const probe = "rqlobj_criteria"
+	return strings.Contains(strings.ToLower(list.SQLGet(probe)), "where "+probe)
+}
+
+// topLevel returns the index of the first of the keywords in the text
+// outside any parentheses or quotes, or -1 if there is none
+func topLevel(text string, keywords ...string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && (i == 0 || !isWordByte(text[i-1])):
+			for _, keyword := range keywords {
+				end := i + len(keyword)
+				if end <= len(text) && strings.EqualFold(text[i:end], keyword) &&
+					(end == len(text) || !isWordByte(text[end])) {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// listScope returns the scope of the objects of the list, if they are
+// scoped to tenants. Lists that are not of objects are not scoped
+func (db RDB) listScope(list DBList) (string, error) {
+	if l, ok := list.(*objectList); ok {
+		return db.scope(l.proto)
+	}
+	create, _, err := appender(list)
+	if err != nil {
+		return "", nil
+	}
+	return db.scope(create())
+}
+
+// checkTenant returns an error unless the object belongs to the db's tenant,
+// or its type is not scoped to tenants
+func (db RDB) checkTenant(o DBObject) error {
+	scoped, ok := o.(TenantScoped)
+	if !ok {
+		return nil
+	}
+	if db.tenant == nil {
+		return ErrNoTenant
+	}
+	dest, err := columnReceivers(o, []string{scoped.TenantColumn()})
+	if err != nil {
+		return err
+	}
+	if formatted(received(dest[0])) != formatted(db.tenant) {
+		return ErrTenantMismatch
+	}
+	return nil
+}
+
+// setTenant gives the object the db's tenant, unless it belongs to another
+func (db RDB) setTenant(o DBObject) error {
+	scoped, ok := o.(TenantScoped)
+	if !ok {
+		return nil
+	}
+	if db.tenant == nil {
+		return ErrNoTenant
+	}
+	dest, err := columnReceivers(o, []string{scoped.TenantColumn()})
+	if err != nil {
+		return err
+	}
+	current := received(dest[0])
+	if !isZero(current) {
+		if formatted(current) != formatted(db.tenant) {
+			return ErrTenantMismatch
+		}
+		return nil
+	}
+	field := reflect.ValueOf(dest[0])
+	tenant := reflect.ValueOf(db.tenant)
+	if field.Kind() != reflect.Ptr ||
+		!tenant.Type().ConvertibleTo(field.Elem().Type()) ||
+		// numbers convert to strings as runes
+		field.Elem().Kind() == reflect.String && tenant.Kind() != reflect.String {
+		return fmt.Errorf("tenant %v cannot be held by %s", db.tenant, field.Type())
+	}
+	field.Elem().Set(tenant.Convert(field.Elem().Type()))
+	return nil
+}
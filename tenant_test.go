@@ -0,0 +1,233 @@
+package rqlobj
+
+import (
+	"errors"
+	"testing"
+)
+
+// tenantStruct belongs to the tenant held by its kind, or the named column
+type tenantStruct struct {
+	testStruct
+	column string
+}
+
+func (o *tenantStruct) TenantColumn() string {
+	if o.column != "" {
+		return o.column
+	}
+	return "kind"
+}
+
+func TestScope(t *testing.T) {
+	var db RDB
+	if scope, err := db.scope(&testStruct{}); err != nil || scope != "" {
+		t.Errorf("unscoped type was scoped: %q (%v)", scope, err)
+	}
+	if _, err := db.scope(&tenantStruct{}); err != ErrNoTenant {
+		t.Errorf("expected ErrNoTenant but got %v", err)
+	}
+	scope, err := db.ForTenant(7).scope(&tenantStruct{})
+	if err != nil || scope != "kind=7" {
+		t.Errorf("bad scope: %q (%v)", scope, err)
+	}
+	if _, err := db.ForTenant(7).ForTenant(nil).scope(&tenantStruct{}); err != ErrNoTenant {
+		t.Errorf("expected the scope to be removed but got %v", err)
+	}
+
+	if got := deleteQuery("", &testStruct{}, 3, ""); got != "delete from test_structs where id=3;" {
+		t.Errorf("bad delete: %s", got)
+	}
+	if got := deleteQuery("ns_", &tenantStruct{}, 3, scope); got != "delete from ns_test_structs where id=3 and kind=7;" {
+		t.Errorf("bad delete: %s", got)
+	}
+	if got := deleteQuery("", &tenantStruct{}, 0, scope); got != "delete from test_structs where kind=7;" {
+		t.Errorf("bad delete all: %s", got)
+	}
+}
+
+// tenantStructs is a list whose SQLGet takes its criteria as is, as generated by dbgen
+type tenantStructs []tenantStruct
+
+func (l *tenantStructs) SQLGet(extra string) string {
+	return "select id,name,kind,data,modified from test_structs " + extra + ";"
+}
+
+func (l *tenantStructs) SQLResults(fn func(...interface{}) error) error {
+	var o tenantStruct
+	if err := fn(o.Receivers()...); err != nil {
+		return err
+	}
+	*l = append(*l, o)
+	return nil
+}
+
+func TestScopeWhere(t *testing.T) {
+	const scope = "kind=7"
+	list := &objectList{proto: &tenantStruct{}}
+	for where, want := range map[string]string{
+		"":                        "kind=7",
+		"limit 5":                 "kind=7 limit 5",
+		"name='x;' limit 5;":      "(name='x;') and kind=7 limit 5",
+		"id=1 or 1=1 order by id": "(id=1 or 1=1) and kind=7 order by id",
+		"name='limit' and id in (select id from t limit 1)": "(name='limit' and id in (select id from t limit 1)) and kind=7",
+		"ordered=1 group by kind":                           "(ordered=1) and kind=7 group by kind",
+	} {
+		if got := scopeWhere(list, where, scope); got != want {
+			t.Errorf("%q: got %s\nwant %s", where, got, want)
+		}
+	}
+	raw := &tenantStructs{}
+	for where, want := range map[string]string{
+		"":                           "where kind=7",
+		"limit 5":                    "where kind=7 limit 5",
+		"where name='x' order by id": "where (name='x') and kind=7 order by id",
+		"join sites s on s.id = kind where s.name='x'": "join sites s on s.id = kind where (s.name='x') and kind=7",
+		"join sites s on s.id = kind limit 2":          "join sites s on s.id = kind where kind=7 limit 2",
+	} {
+		if got := scopeWhere(raw, where, scope); got != want {
+			t.Errorf("%q: got %s\nwant %s", where, got, want)
+		}
+	}
+	if got := scopeWhere(list, "limit 5", ""); got != "limit 5" {
+		t.Errorf("unscoped criteria changed: %s", got)
+	}
+}
+
+func TestListScoped(t *testing.T) {
+	db, local := sqliteServer(t)
+	if _, err := local.Exec(queryCreate); err != nil {
+		t.Fatal(err)
+	}
+	// the rows of other tenants come first
+	for i, kind := range []int{8, 8, 8, 7, 7, 7} {
+		if _, err := local.Exec("insert into test_structs (id, name, kind) values (?, 'x', ?)", i+1, kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create := func() DBObject { return &tenantStruct{} }
+	objects := &objectList{proto: create(), create: create}
+	var raw tenantStructs
+	for _, list := range []DBList{objects, &raw} {
+		if err := db.ForTenant(7).ListQuery(list, "limit 2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kinds := []int{}
+	for _, o := range objects.objs {
+		kinds = append(kinds, o.(*tenantStruct).Kind)
+	}
+	for _, o := range raw {
+		kinds = append(kinds, o.Kind)
+	}
+	if len(kinds) != 4 || kinds[0] != 7 || kinds[1] != 7 || kinds[2] != 7 || kinds[3] != 7 {
+		t.Errorf("listed objects of tenants %v, want only 2 each of tenant 7", kinds)
+	}
+}
+
+func TestDeleteScoped(t *testing.T) {
+	db, local := sqliteServer(t)
+	for _, query := range []string{
+		queryCreate,
+		"insert into test_structs (id, kind) values (1, 7), (2, 8)",
+	} {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.ForTenant(7).DeleteByID(&tenantStruct{}, 2); err == nil {
+		t.Error("deleted the object of another tenant")
+	}
+	if err := db.ForTenant(7).DeleteByID(&tenantStruct{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	local.QueryRow("select group_concat(id) from test_structs").Scan(&left)
+	if left != 2 {
+		t.Errorf("left object %d, want 2", left)
+	}
+}
+
+func TestAddConflict(t *testing.T) {
+	db, local := sqliteServer(t)
+	for _, query := range []string{
+		queryCreate,
+		"insert into test_structs (id, name, kind) values (1, 'x', 7), (2, 'y', 8)",
+	} {
+		if _, err := local.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Add(&testStruct{ID: 1, Name: "z"}); err != ErrDuplicateKey {
+		t.Errorf("expected ErrDuplicateKey but got %v", err)
+	}
+	if err := db.ForTenant(7).Add(&tenantStruct{testStruct: testStruct{ID: 1}}); err != ErrDuplicateKey {
+		t.Errorf("expected ErrDuplicateKey but got %v", err)
+	}
+	if err := db.ForTenant(7).Add(&tenantStruct{testStruct: testStruct{ID: 2}}); err != ErrTenantMismatch {
+		t.Errorf("expected ErrTenantMismatch but got %v", err)
+	}
+	o := &tenantStruct{testStruct: testStruct{ID: 3}}
+	if err := db.ForTenant(7).Add(o); err != nil {
+		t.Fatal(err)
+	}
+	var names string
+	local.QueryRow("select group_concat(name) from test_structs").Scan(&names)
+	if names != "x,y," {
+		t.Errorf("names are %q, want the rows taking the keys unchanged", names)
+	}
+}
+
+func TestSetTenant(t *testing.T) {
+	db := RDB{}.ForTenant(int64(7))
+	o := &tenantStruct{}
+	if err := db.setTenant(o); err != nil || o.Kind != 7 {
+		t.Errorf("tenant not set: %d (%v)", o.Kind, err)
+	}
+	if err := db.setTenant(o); err != nil {
+		t.Errorf("object of the tenant refused: %v", err)
+	}
+	if err := db.checkTenant(o); err != nil {
+		t.Errorf("object of the tenant refused: %v", err)
+	}
+	other := &tenantStruct{testStruct: testStruct{Kind: 8}}
+	if err := db.setTenant(other); err != ErrTenantMismatch {
+		t.Errorf("expected ErrTenantMismatch but got %v", err)
+	}
+	if err := db.checkTenant(other); err != ErrTenantMismatch {
+		t.Errorf("expected ErrTenantMismatch but got %v", err)
+	}
+	if err := db.setTenant(&tenantStruct{column: "name"}); err == nil {
+		t.Error("expected an error for a number tenant in a string column")
+	}
+	named := &tenantStruct{column: "name"}
+	if err := db.ForTenant("acme").setTenant(named); err != nil || named.Name != "acme" {
+		t.Errorf("tenant not set: %q (%v)", named.Name, err)
+	}
+}
+
+func TestUnscoped(t *testing.T) {
+	var db RDB
+	o := &tenantStruct{testStruct: testStruct{ID: 1, Kind: 7}}
+	checks := map[string]error{
+		"Add":        db.Add(o),
+		"Update":     db.Update(o),
+		"LoadBy":     db.LoadBy(o, "id", 1),
+		"Load":       db.Load(o, map[string]interface{}{"id": 1}),
+		"DeleteByID": db.DeleteByID(o, 1),
+		"ListQuery":  db.ListQuery(&objectList{proto: o}, ""),
+	}
+	_, checks["Search"] = db.Search(&[]*tenantStruct{}, "x", nil)
+	_, checks["Import"] = db.Import(o, nil, JSONLines)
+	for name, err := range checks {
+		if !errors.Is(err, ErrNoTenant) {
+			t.Errorf("%s: expected ErrNoTenant but got %v", name, err)
+		}
+	}
+	other := &tenantStruct{testStruct: testStruct{ID: 2, Kind: 8}}
+	if err := db.ForTenant(7).Update(other); err != ErrTenantMismatch {
+		t.Errorf("expected ErrTenantMismatch but got %v", err)
+	}
+	if err := db.ForTenant(7).Link(o, "Groups", other); err != ErrTenantMismatch {
+		t.Errorf("expected ErrTenantMismatch but got %v", err)
+	}
+}
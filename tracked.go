@@ -0,0 +1,113 @@
+package rqlobj
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Tracker is implemented by objects that track changes to their fields,
+// as generated by dbgen for types embedding Tracked
+type Tracker interface {
+	// Snapshot records the field values, for Changed to compare against
+	Snapshot()
+
+	// Changed returns the columns of the fields modified since the Snapshot
+	Changed() []string
+}
+
+var trackerType = reflect.TypeOf((*Tracker)(nil)).Elem()
+
+// Tracked is embedded in objects to track the changes to their fields,
+// so that Update only writes the changed columns, if any.
+// The snapshot is taken whenever the object is loaded, added or updated
+type Tracked struct {
+	snapshot map[string]string
+}
+
+// Record records the values of the object's fields
+func (t *Tracked) Record(o DBObject) {
+	columns := selectColumns(o)
+	receivers := o.Receivers()
+	t.snapshot = make(map[string]string, len(columns))
+	for i, column := range columns {
+		if i < len(receivers) {
+			t.snapshot[column] = formatted(received(receivers[i]))
+		}
+	}
+}
+
+// Compare returns the updatable columns of the object whose values differ
+// from those recorded, or all of them if none were recorded
+func (t *Tracked) Compare(o DBObject) []string {
+	columns := updateColumns(o)
+	if t.snapshot == nil {
+		return columns
+	}
+	values := columnValues(o)
+	var changed []string
+	for _, column := range columns {
+		if was, ok := t.snapshot[column]; !ok || was != formatted(values[column]) {
+			changed = append(changed, column)
+		}
+	}
+	return changed
+}
+
+// updateColumns returns the columns that Update writes: those
+// that are inserted, but not the keys
+func updateColumns(o DBObject) []string {
+	var columns []string
+	for _, field := range strings.Split(o.InsertFields(), ",") {
+		field = strings.TrimSpace(field)
+		if field != "" && !within(field, o.KeyFields()) {
+			columns = append(columns, field)
+		}
+	}
+	return columns
+}
+
+// columnValues returns the values of the object's select fields by column
+func columnValues(o DBObject) map[string]interface{} {
+	columns := selectColumns(o)
+	receivers := o.Receivers()
+	values := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if i < len(receivers) {
+			values[column] = received(receivers[i])
+		}
+	}
+	return values
+}
+
+// snapshot records the state of the tracked objects loaded,
+// which is an object, an objectList, or a pointer to a slice of objects
+func snapshot(loaded interface{}) {
+	switch l := loaded.(type) {
+	case Tracker:
+		l.Snapshot()
+		return
+	case DBObject:
+		return
+	case *objectList:
+		loaded = l.objs
+	default:
+		// avoid listing the objects when their type is not tracked
+		t := reflect.TypeOf(loaded)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Slice {
+			return
+		}
+		elem := t.Elem().Elem()
+		if elem.Kind() != reflect.Ptr {
+			elem = reflect.PtrTo(elem)
+		}
+		if !elem.Implements(trackerType) {
+			return
+		}
+	}
+	objs, _ := objectsOf(loaded)
+	for _, o := range objs {
+		if t, ok := o.(Tracker); ok {
+			t.Snapshot()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rqlobj
+
+import (
+	"testing"
+)
+
+// trackedStruct tracks its changes, as generated by dbgen
+type trackedStruct struct {
+	testStruct
+	Tracked
+}
+
+func (o *trackedStruct) Snapshot() {
+	o.Tracked.Record(o)
+}
+
+func (o *trackedStruct) Changed() []string {
+	return o.Tracked.Compare(o)
+}
+
+func TestTracked(t *testing.T) {
+	o := &trackedStruct{testStruct: testStruct{ID: 1, Name: "one", Kind: 1}}
+	if changed := o.Changed(); len(changed) != 3 {
+		t.Errorf("expected all columns without a snapshot but got %v", changed)
+	}
+	o.Snapshot()
+	if changed := o.Changed(); len(changed) != 0 {
+		t.Errorf("expected no changes but got %v", changed)
+	}
+	o.Kind = 2
+	changed := o.Changed()
+	if len(changed) != 1 || changed[0] != "kind" {
+		t.Errorf("expected kind to change but got %v", changed)
+	}
+	const query = "update test_structs set kind=2 where id=1"
+	if got, err := updateQuery("", o, changed); err != nil || got != query {
+		t.Errorf("got %s (%v)\nwant %s", got, err, query)
+	}
+	// the key is never updated
+	o.ID = 3
+	if changed := o.Changed(); len(changed) != 1 {
+		t.Errorf("expected only kind to change but got %v", changed)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	list := []trackedStruct{{testStruct: testStruct{ID: 1}}, {testStruct: testStruct{ID: 2}}}
+	snapshot(&list)
+	list[1].Name = "two"
+	if changed := list[0].Changed(); len(changed) != 0 {
+		t.Errorf("expected no changes but got %v", changed)
+	}
+	if changed := list[1].Changed(); len(changed) != 1 || changed[0] != "name" {
+		t.Errorf("expected name to change but got %v", changed)
+	}
+	o := &trackedStruct{}
+	snapshot(&objectList{objs: []DBObject{o}})
+	if changed := o.Changed(); len(changed) != 0 {
+		t.Errorf("objects of an objectList were not recorded: %v", changed)
+	}
+	// untracked objects are ignored
+	snapshot(&_testStruct{{ID: 1}})
+	snapshot(&testStruct{})
+}
+
+func TestUpdateQuery(t *testing.T) {
+	s := &testStruct{ID: 4, Name: "o'four", Kind: 4}
+	const query = "update test_structs set name='o''four',kind=4,data='' where id=4"
+	if got, err := updateQuery("", s, updateColumns(s)); err != nil || got != query {
+		t.Errorf("got %s (%v)\nwant %s", got, err, query)
+	}
+}